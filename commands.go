@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// subcommand is a mode of the tool selected by the first argument, e.g.
+// "convertSLX detect -d models". Invoked without one, the tool takes every
+// flag on a single command line as before.
+type subcommand struct {
+	args    string // the positional arguments, for the usage line
+	summary string
+	// modes are boolean flags the subcommand switches on
+	modes []string
+	// accepts reports whether the named flag applies to the subcommand
+	accepts func(name string) bool
+}
+
+// modeFlags select what a run does and are replaced by the subcommands.
+var modeFlags = map[string]bool{
+	"detect": true, "info": true, "report-releases": true, "print-tree": true, "normalize": true,
+	"list-releases": true, "selftest": true, "rollback": true,
+}
+
+// scanFlags control which files a run visits, and how it reports them.
+var scanFlags = map[string]bool{
+	"d": true, "directory": true, "recursive": true, "from-file": true, "ext": true, "types": true,
+	"include-hidden": true, "follow-symlinks": true, "max-depth": true,
+	"include": true, "exclude": true, "jobs": true, "min-size": true,
+	"on-error": true, "fail-fast": true, "in-zip": true, "clean-workdirs": true,
+	"workdir": true, "tmp": true, "v": true, "verbose": true, "q": true,
+	"quiet": true, "json": true, "summary": true, "config": true,
+}
+
+// targetFlags choose or guard the target release and which tags carry it,
+// so they mean nothing to a normalization.
+var targetFlags = map[string]bool{
+	"r": true, "release": true, "version-string": true, "release-from": true,
+	"downgrade-only": true, "upgrade-only": true, "check-only": true,
+	"compat-table": true, "tags": true, "text-tags": true, "skip-tag": true, "diff": true,
+	"exclude-release": true, "emit-changes": true, "linked": true,
+}
+
+var subcommands = map[string]subcommand{
+	"convert": {
+		args:    "--release REL [options] <input.slx, directory, or - for stdin>",
+		summary: "Convert archives to a target release (the default without a command).",
+		accepts: func(name string) bool { return !modeFlags[name] },
+	},
+	"detect": {
+		args:    "[options] <input.slx or directory>",
+		summary: "Print the release of each archive without converting it.",
+		modes:   []string{"detect"},
+		accepts: func(name string) bool { return scanFlags[name] || name == "report-releases" || name == "print-tree" },
+	},
+	"normalize": {
+		args:    "[options] <input.slx or directory>",
+		summary: "Rezip archives the way MATLAB expects, leaving their release as it is.",
+		modes:   []string{"normalize"},
+		accepts: func(name string) bool {
+			return !modeFlags[name] && !targetFlags[name] && !isReleaseFlag(name)
+		},
+	},
+	"list-releases": {
+		summary: "Print the supported release strings.",
+		modes:   []string{"list-releases"},
+		accepts: func(string) bool { return false },
+	},
+}
+
+// commandOrder is the order subcommands are listed in the usage message.
+var commandOrder = []string{"convert", "detect", "normalize", "list-releases"}
+
+// parseCommandLine parses the command line like flag.Parse. If the first
+// argument names a subcommand, only the flags that subcommand accepts are
+// defined, its mode flags are switched on, and flag.CommandLine is
+// replaced by its flag set so that flag.Args and flag.Visit see it. The
+// flags share their values with the globals, so the rest of main is none
+// the wiser.
+func parseCommandLine() {
+	if len(os.Args) < 2 {
+		flag.Parse()
+		return
+	}
+	name := os.Args[1]
+	cmd, ok := subcommands[name]
+	if !ok {
+		flag.Parse()
+		return
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var accepted int
+	flag.VisitAll(func(f *flag.Flag) {
+		if cmd.accepts(f.Name) {
+			fs.Var(f.Value, f.Name, f.Usage)
+			accepted++
+		}
+	})
+	prog := filepath.Base(os.Args[0])
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n\n%s\n", strings.TrimSpace(prog+" "+name+" "+cmd.args), cmd.summary)
+		if accepted > 0 {
+			fmt.Fprintf(os.Stderr, "\nOptions:\n")
+			fs.PrintDefaults()
+		}
+	}
+	for _, mode := range cmd.modes {
+		flag.Set(mode, "true")
+	}
+	fs.Parse(os.Args[2:])
+	flag.CommandLine = fs
+}
+
+// printCommands adds the subcommands to the usage message.
+func printCommands(prog string) {
+	fmt.Fprintf(os.Stderr, "Commands (optional; run %s <command> -h for its options):\n", prog)
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", name, subcommands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// isReleaseFlag reports whether name is one of the deprecated per-release
+// flags such as r2023b.
+func isReleaseFlag(name string) bool {
+	for rel := range releaseFlags {
+		if strings.ToLower(rel) == name {
+			return true
+		}
+	}
+	return false
+}
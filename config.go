@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// configFileName is looked up in the current directory when --config is
+// not given.
+const configFileName = ".slxconvert.json"
+
+// fileConfig holds the defaults a project can commit alongside its models.
+// Every field is optional; anything set on the command line wins.
+type fileConfig struct {
+	Release          string   `json:"release"`
+	CompressionLevel string   `json:"compression_level"`
+	Include          []string `json:"include"`
+	Exclude          []string `json:"exclude"`
+	Suffix           string   `json:"suffix"`
+	NoClobber        bool     `json:"no_clobber"`
+	SkipTags         []string `json:"skip_tags"`
+	TextTags         []string `json:"text_tags"`
+}
+
+// loadConfig reads the config file at path, or configFileName in the
+// current directory when path is empty. A missing default file is not an
+// error; a missing explicit one is.
+func loadConfig(path string) (*fileConfig, error) {
+	explicit := path != ""
+	if !explicit {
+		path = configFileName
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cfg fileConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// applyConfig fills in options from cfg that were not set on the command
+// line. It must run after flag.Parse.
+func applyConfig(cfg *fileConfig) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if cfg.Release != "" && !releaseGiven() {
+		*releaseName = cfg.Release
+	}
+	if cfg.CompressionLevel != "" && !set["compression-level"] {
+		*compression = cfg.CompressionLevel
+	}
+	if cfg.Suffix != "" && !set["suffix"] {
+		*outputSuffix = cfg.Suffix
+	}
+	if cfg.NoClobber && !set["no-clobber"] {
+		*noClobber = true
+	}
+	if !set["include"] {
+		includePatterns = append(includePatterns, cfg.Include...)
+	}
+	if !set["exclude"] {
+		excludePatterns = append(excludePatterns, cfg.Exclude...)
+	}
+	if !set["skip-tag"] {
+		skipTags = append(skipTags, cfg.SkipTags...)
+	}
+	if len(cfg.TextTags) > 0 && !set["text-tags"] {
+		*textTags = strings.Join(cfg.TextTags, ",")
+	}
+}
@@ -2,208 +2,1798 @@ package main
 
 import (
 	"archive/zip"
+	"bufio"
 	"compress/flate"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/beevik/etree"
+	"convertSLX/slxconvert"
 )
 
+// releaseFlags holds the deprecated boolean flag for each supported
+// release, e.g. --r2023b for R2023b, which is still accepted as a synonym
+// for --release R2023b.
+var releaseFlags = func() map[string]*bool {
+	flags := make(map[string]*bool, len(slxconvert.SupportedReleases))
+	for _, rel := range slxconvert.SupportedReleases {
+		flags[rel] = flag.Bool(strings.ToLower(rel), false, "Deprecated: use --release "+rel)
+	}
+	return flags
+}()
+
 var (
-	r2023b = flag.Bool("r2023b", false, "Set output to R2023b")
-	r2024a = flag.Bool("r2024a", false, "Set output to R2024a")
-	r2024b = flag.Bool("r2024b", false, "Set output to R2024b")
-	r2023a = flag.Bool("r2023a", false, "Set output to R2023a")
-	r2022b = flag.Bool("r2022b", false, "Set output to R2022b")
-	r2022a = flag.Bool("r2022a", false, "Set output to R2022a")
+	releaseName    = flag.String("release", "", "Target release, one of the supported releases (see --list-releases)")
+	versionString  = flag.String("version-string", "", "Set output to an arbitrary release, e.g. R2025a")
+	releaseFrom    = flag.String("release-from", "", "Set output to the release of this reference archive")
+	interactive    = flag.Bool("interactive", false, "Ask for the target release on stdin when none is given (the default when stdin is a terminal)")
+	includeHidden  = flag.Bool("include-hidden", false, "Also scan dotfiles, lock files and other junk in directory mode")
+	followSymlinks = flag.Bool("follow-symlinks", false, "Follow symbolic links during directory scans")
+	maxDepth       = flag.Int("max-depth", -1, "Limit directory recursion depth (0 = top level only, -1 = unlimited)")
+	extraExts      = flag.String("ext", "", "Comma-separated extra file extensions to convert in directory mode")
+	fileTypes      = flag.String("types", "", "Comma-separated file types to convert in directory mode, e.g. slx,sldd (default all)")
+	excludeRelease = flag.String("exclude-release", "", "Comma-separated releases, e.g. R2023b,R2024a; files already at one of them are skipped")
+	downgradeOnly  = flag.Bool("downgrade-only", false, "Refuse to convert a file to a newer release")
+	upgradeOnly    = flag.Bool("upgrade-only", false, "Refuse to convert a file to an older release")
+	checkOnly      = flag.Bool("check-only", false, "Report uses of features the target release predates without converting")
+	compatTable    = flag.String("compat-table", "", "JSON file of extra {name, introduced} features to warn about on downgrade")
+	verify         = flag.Bool("verify", false, "Reopen each output and check its version tags")
+	normalize      = flag.Bool("normalize", false, "Rewrite each archive with MATLAB-compatible zip structure, leaving its release as it is")
+	stripExtra     = flag.Bool("strip-extra", false, "Drop the archive comment and per-entry extra fields and comments")
+	compression    = flag.String("compression-level", "default", "Deflate level 0-9, or none, fast, best")
+	maxEntryFlag   = flag.String("max-entry-size", "", "Refuse archives with an entry larger than this uncompressed, e.g. 512M (default 2G, -1 = no limit)")
+	maxTotalFlag   = flag.String("max-total-size", "", "Refuse archives larger than this uncompressed, e.g. 4G (default 8G, -1 = no limit)")
+	extraTags      = flag.String("tags", "", "Comma-separated extra element names (or element@attr) to rewrite, e.g. slVersion")
+	textTags       = flag.String("text-tags", "", "Comma-separated elements whose text mentions the release, e.g. description; only the release in it is replaced")
+	listReleases   = flag.Bool("list-releases", false, "Print the supported release strings and exit")
+	selfTest       = flag.Bool("selftest", false, "Check that archives written here keep MATLAB's zip invariants and exit")
+	dryRun         = flag.Bool("dry-run", false, "Report what would change without writing any files")
+	preserveMtime  = flag.Bool("preserve-mtime", true, "Keep the original file modification time on the output")
+	inMemory       = flag.Bool("in-memory", false, "Convert in memory without a temporary directory")
+	printTreeFlag  = flag.Bool("print-tree", false, "List the entries of each archive with their size, compression method and modification time, without converting")
+	reportReleases = flag.Bool("report-releases", false, "Tally the releases of the archives below a directory and print a histogram, without converting")
+	execHook       = flag.String("exec", "", "Run this command after each converted file, with {} replaced by the output path")
+	timings        = flag.Bool("timings", false, "Print how long each file took to convert (also added to --json)")
+	pathsOnly      = flag.Bool("stdout-summary-only", false, "Print only the path of each file produced on stdout, for build rules; everything else goes to stderr")
+	summary        = flag.Bool("summary", false, "Print a one-line count of converted, skipped and failed files at the end")
+	jsonOutput     = flag.Bool("json", false, "Print a JSON summary of the run to stdout")
+	failFast       = flag.Bool("fail-fast", false, "Stop a directory run at the first error (same as --on-error=stop)")
+	onError        = flag.String("on-error", "collect", "What a directory run does after a failed file: skip, stop or collect")
+	minSize        = flag.Int64("min-size", minZipSize, "Skip files smaller than this many bytes in directory and list runs")
+	fromFile       = flag.String("from-file", "", "Convert the files listed one per line in this file (- for stdin)")
+	jobs           = flag.Int("jobs", runtime.NumCPU(), "Number of files to convert in parallel in directory mode")
+	inZip          = flag.Bool("in-zip", false, "Treat a .zip input as a batch of models: convert its contents and repackage it")
+	linked         = flag.Bool("linked", false, "Convert each folder (or blank-line-separated --from-file group) as a linked set that must end at one release")
+	parallelSafe   = flag.Bool("parallel-safe", false, "Lock each file while converting it and skip files locked by another run")
+	staleLock      = flag.Duration("stale-lock", time.Hour, "Break --parallel-safe locks older than this, left by crashed runs (0 = never)")
+	outputDir      = flag.String("output-dir", "", "Write outputs to this directory, mirroring the input tree")
+	outputSuffix   = flag.String("suffix", "", "Append suffix to output file names instead of overwriting, e.g. _converted")
+	noClobber      = flag.Bool("no-clobber", false, "Refuse to overwrite the input file in place")
+	force          = flag.Bool("force", false, "Overwrite an existing output file")
+	forceWritable  = flag.Bool("force-writable", false, "Convert read-only files too, restoring their permissions afterwards")
+	configPath     = flag.String("config", "", "Read default options from this JSON file (default ./"+configFileName+" if present)")
+	workDir        = flag.String("workdir", "", "Create temporary extraction directories here (default $"+workDirEnv+" or the OS temp dir)")
+	cleanWorkDirs  = flag.Bool("clean-workdirs", false, "Delete leftover work directories found while scanning a directory")
+	keepWorkDir    = flag.Bool("keep-workdir", false, "Leave each extracted work directory in place and print its path")
+	retries        = flag.Int("retries", 0, "Retry a file up to N times after a transient file system error")
+	timeout        = flag.Duration("timeout", 0, "Abandon a file's conversion after this long, e.g. 30s (0 = no limit)")
+	emitChanges    = flag.String("emit-changes", "", "With --dry-run, write a report of the planned changes of each model under this directory")
+	diffMode       = flag.Bool("diff", false, "Print a diff-like view of each rewritten element")
+	checksum       = flag.Bool("checksum", false, "Record SHA-256 checksums of each input and output")
+	backup         = flag.Bool("backup", false, "Save a copy of the input as <input>.bak before converting")
+	manifestPath   = flag.String("manifest", "", "Write a JSON record of the converted files, their backups and checksums here, for --rollback")
+	rollbackPath   = flag.String("rollback", "", "Restore the files converted by the run that wrote this --manifest from their backups, then exit")
 )
+
+// minZipSize is the size of an empty zip archive, which is nothing but its
+// end-of-central-directory record; anything smaller cannot be an archive.
+const minZipSize = 22
+
+// workDirEnv names the environment variable used for --workdir when the
+// flag is not given.
+const workDirEnv = "SLXCONVERT_WORKDIR"
+
+// releaseEnv names the environment variable that supplies the target
+// release when no release flag is given. It takes precedence over a config
+// file.
+const releaseEnv = "SLXCONVERT_RELEASE"
+
 var selectedRelease string
+var detectMode bool
+var verbose bool
+var quiet bool
+var compressionLevel int
+var storeEntries bool
+
+// maxEntrySize and maxTotalSize are the parsed --max-entry-size and
+// --max-total-size; zero leaves the library defaults.
+var maxEntrySize, maxTotalSize int64
+
+// archiveExts are the file types picked up in directory mode: the
+// ZIP-based formats and legacy text .mdl models. --ext adds to this list.
+var archiveExts = []string{".slx", ".sldd", ".mldatx", ".slxc", ".slxp", ".mdl"}
+
+// isArchiveExt reports whether name has one of the archiveExts, possibly
+// followed by slxconvert.GzipExt.
+func isArchiveExt(name string) bool {
+	// model.slx.gz is a gzipped model.slx; .mdl models are only read
+	// uncompressed
+	ext := strings.ToLower(filepath.Ext(slxconvert.Unwrapped(name)))
+	if ext == ".mdl" && slxconvert.IsGzip(name) {
+		return false
+	}
+	for _, e := range archiveExts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// scanTypes holds the --types extensions, or is nil to scan for all of
+// archiveExts.
+var scanTypes map[string]bool
+
+// wantedType reports whether a directory run should pick up name, which
+// isArchiveExt has accepted, under --types.
+func wantedType(name string) bool {
+	return scanTypes == nil || scanTypes[strings.ToLower(filepath.Ext(slxconvert.Unwrapped(name)))]
+}
+
+// reportSummary prints a one-line count of the changes made to archive,
+// e.g. "model.slx: R2024b -> R2023b: updated 3 tag(s) in 2 file(s)".
+func reportSummary(archive, from string, changes []slxconvert.TagChange) {
+	if from != "" {
+		from += " -> "
+	}
+	verb := "updated"
+	if *dryRun {
+		verb = "would update"
+	}
+	printf("%s: %s%s: %s %d tag(s) in %d file(s)\n", archive, from, selectedRelease, verb, len(changes), slxconvert.ChangedFiles(changes))
+}
+
+// reportChanges prints the changes made to an archive in dry-run mode.
+func reportChanges(changes []slxconvert.TagChange) {
+	printf("%s", formatChanges(changes))
+}
+
+// formatChanges returns the listing printed by reportChanges, one line per
+// element with its name as written in the file.
+func formatChanges(changes []slxconvert.TagChange) string {
+	var b strings.Builder
+	for _, c := range changes {
+		fmt.Fprintf(&b, "  %s: <%s> %q -> %q\n", c.File, c.Qualified(), c.Old, c.New)
+	}
+	return b.String()
+}
+
+// printDiff prints changes as a diff-like listing per metadata file of
+// archive, one -/+ pair for every rewritten element. It is meant to be
+// read, not applied: the files live inside the archive.
+func printDiff(archive string, changes []slxconvert.TagChange) {
+	resultf("%s", formatDiff(archive, changes))
+}
+
+// formatDiff returns the listing printed by printDiff.
+func formatDiff(archive string, changes []slxconvert.TagChange) string {
+	var b strings.Builder
+	file := ""
+	for _, c := range changes {
+		if c.File != file {
+			file = c.File
+			fmt.Fprintf(&b, "--- a/%s/%s\n+++ b/%s/%s\n", archive, file, archive, file)
+		}
+		if elem, attr, ok := strings.Cut(c.Qualified(), "@"); ok {
+			fmt.Fprintf(&b, "-<%s %s=%q>\n+<%s %s=%q>\n", elem, attr, c.Old, elem, attr, c.New)
+		} else {
+			fmt.Fprintf(&b, "-<%s>%s</%s>\n+<%s>%s</%s>\n", elem, c.Old, elem, elem, c.New, elem)
+		}
+	}
+	return b.String()
+}
+
+// writeChangeReport saves the planned changes to slx, a conversion from
+// release from, as a report under --emit-changes, mirroring the input
+// tree, and returns its path. Models with nothing to change get no report.
+func writeChangeReport(slx, from string, changes []slxconvert.TagChange) (string, error) {
+	if len(changes) == 0 {
+		return "", nil
+	}
+	rel := treeRel(slx)
+	report := filepath.Join(*emitChanges, rel+".changes.txt")
+	if err := os.MkdirAll(filepath.Dir(report), 0o755); err != nil {
+		return "", err
+	}
+	if from == "" {
+		from = "unknown release"
+	}
+	body := fmt.Sprintf("%s: %s -> %s\n%s", filepath.ToSlash(rel), from, selectedRelease, formatChanges(changes))
+	return report, slxconvert.WriteAtomic(report, func(w io.Writer) error {
+		_, err := io.WriteString(w, body)
+		return err
+	})
+}
+
+// printDetected prints a single line describing the release of slx.
+func printDetected(slx string) error {
+	name := shownPath(slx)
+	found, err := slxconvert.Detect(slx)
+	if err != nil {
+		recordResult(fileResult{Input: name, Error: err.Error()})
+		return err
+	}
+	recordResult(fileResult{Input: name, OriginalRelease: slxconvert.PrimaryRelease(found)})
+	var parts []string
+	for _, tag := range append(append([]string(nil), slxconvert.VersionTags...), slxconvert.MDLVersionKey) {
+		if val, ok := found[tag]; ok {
+			parts = append(parts, tag+"="+val)
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "unknown")
+	}
+	if !*reportReleases {
+		resultf("%s: %s\n", name, strings.Join(parts, " "))
+	}
+	return nil
+}
+
+// printTree lists the entries of the archive slx, one per line with its
+// uncompressed size, compression method and modification time, so that a
+// conversion that changes nothing can be traced to missing metadata. A
+// text .mdl model has no entries and gets a single line.
+func printTree(slx string) error {
+	name := shownPath(slx)
+	if slxconvert.IsMDL(slx) {
+		info, err := os.Stat(slx)
+		if err != nil {
+			recordResult(fileResult{Input: name, Error: err.Error()})
+			return err
+		}
+		recordResult(fileResult{Input: name})
+		resultf("%s: text model, %d bytes (no archive entries)\n", name, info.Size())
+		return nil
+	}
+	r, closeInput, err := slxconvert.OpenInput(slx)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+		recordResult(fileResult{Input: name, Error: err.Error()})
+		return err
+	}
+	defer closeInput()
+	recordResult(fileResult{Input: name})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d entries\n", name, len(r.File))
+	for _, f := range r.File {
+		method := "Method " + strconv.Itoa(int(f.Method))
+		switch f.Method {
+		case zip.Store:
+			method = "Store"
+		case zip.Deflate:
+			method = "Deflate"
+		}
+		fmt.Fprintf(&b, "  %10d  %-8s %s  %s\n", f.UncompressedSize64, method, f.Modified.Format("2006-01-02 15:04:05"), f.Name)
+	}
+	resultf("%s", b.String())
+	return nil
+}
+
+// printCompatibility reports, without converting, the known features slx
+// uses that selectedRelease predates.
+func printCompatibility(slx string) error {
+	name := shownPath(slx)
+	res := fileResult{Input: name, TargetRelease: selectedRelease}
+	if found, err := slxconvert.Detect(slx); err == nil {
+		res.OriginalRelease = slxconvert.PrimaryRelease(found)
+	}
+	issues, err := slxconvert.CheckCompatibility(slx, selectedRelease, slxconvert.KnownFeatures)
+	if err != nil {
+		res.Error = err.Error()
+		recordResult(res)
+		return err
+	}
+	res.Incompatibilities = issues
+	recordResult(res)
+
+	if len(issues) == 0 {
+		resultf("%s: no known incompatibilities with %s\n", name, selectedRelease)
+		return nil
+	}
+	for _, in := range issues {
+		if in.Count == 0 {
+			// a text model is only checked by the release it was saved in
+			resultf("%s: saved in %s, which has the %s (introduced in %s) that %s predates; text models are not scanned for it\n",
+				name, res.OriginalRelease, in.Feature.Name, in.Feature.Introduced, selectedRelease)
+			continue
+		}
+		resultf("%s: %s uses the %s (introduced in %s, %d found), which %s predates\n",
+			name, in.File, in.Feature.Name, in.Feature.Introduced, in.Count, selectedRelease)
+	}
+	return nil
+}
+
+// resolveRelease works out the target release from -r (short), --release,
+// the deprecated per-release flags, --version-string and --release-from,
+// which are mutually exclusive. Only --version-string and --release-from
+// may name a release outside slxconvert.SupportedReleases. An empty
+// release is only allowed in detect mode.
+func resolveRelease(short string) (string, error) {
+	if *normalize {
+		// each file keeps its own release; one from a config file is ignored
+		var err error
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "r":
+				err = errors.New("--normalize keeps each file's release and cannot be combined with -r")
+			case "release", "version-string", "release-from":
+				err = fmt.Errorf("--normalize keeps each file's release and cannot be combined with --%s", f.Name)
+			}
+		})
+		return "", err
+	}
+	// given names the setting rel came from, for the conflict errors
+	var given string
+	// the variable sets a default for conversions; a detect run in the
+	// same environment has no use for it
+	if env := os.Getenv(releaseEnv); env != "" && !releaseGiven() && !detectMode {
+		if norm, err := slxconvert.NormalizeRelease(env); err == nil {
+			env = norm
+		}
+		if !slxconvert.IsSupportedRelease(env) {
+			return "", fmt.Errorf("unsupported release %s=%q (supported: %s)", releaseEnv, env, strings.Join(slxconvert.SupportedReleases, ", "))
+		}
+		*releaseName = env
+		given = releaseEnv + "=" + env
+	}
+	rel, err := releaseArg("--release", *releaseName)
+	if err != nil {
+		return "", err
+	}
+	if given == "" {
+		given = "--release " + rel
+	}
+	if short, err = releaseArg("-r", short); err != nil {
+		return "", err
+	}
+	if short != "" {
+		if rel != "" && rel != short {
+			return "", fmt.Errorf("-r %s and --release %s disagree", short, rel)
+		}
+		if rel == "" {
+			given = "-r " + short
+		}
+		rel = short
+	}
+	if rel != "" && !slxconvert.IsSupportedRelease(rel) {
+		return "", fmt.Errorf("unsupported release %q (supported: %s; use --version-string for others)", rel, strings.Join(slxconvert.SupportedReleases, ", "))
+	}
+
+	for _, name := range slxconvert.SupportedReleases {
+		if !*releaseFlags[name] {
+			continue
+		}
+		flagName := "--" + strings.ToLower(name)
+		if rel != "" && rel != name {
+			return "", fmt.Errorf("conflicting releases: %s and %s", given, flagName)
+		}
+		warnf("Warning: %s is deprecated; use --release %s\n", flagName, name)
+		if rel == "" {
+			given = flagName
+		}
+		rel = name
+	}
+
+	if *versionString != "" {
+		if rel != "" {
+			return "", fmt.Errorf("--version-string cannot be combined with %s", given)
+		}
+		if rel, err = releaseArg("--version-string", *versionString); err != nil {
+			return "", err
+		}
+		given = "--version-string " + rel
+	}
+
+	if *releaseFrom != "" {
+		if rel != "" {
+			return "", fmt.Errorf("--release-from cannot be combined with %s", given)
+		}
+		found, err := slxconvert.Detect(*releaseFrom)
+		if err != nil {
+			return "", fmt.Errorf("--release-from %s: %w", *releaseFrom, err)
+		}
+		if rel = slxconvert.PrimaryRelease(found); !slxconvert.ReleasePattern.MatchString(rel) {
+			return "", fmt.Errorf("--release-from %s: no usable release found (got %q)", *releaseFrom, rel)
+		}
+		printf("Using release %s from %s\n", rel, *releaseFrom)
+	}
+
+	if rel == "" && !detectMode && (*interactive || promptable()) {
+		return promptRelease(os.Stdin)
+	}
+	if rel == "" && !detectMode {
+		return "", fmt.Errorf("no target release given: use --release with one of %s, --version-string, or set %s", strings.Join(slxconvert.SupportedReleases, ", "), releaseEnv)
+	}
+	return rel, nil
+}
+
+// releaseArg returns the release s given by source, a flag name, in its
+// canonical form: r2023b, R2023B and 2023b all give R2023b. An empty s is
+// returned as it is.
+func releaseArg(source, s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	rel, err := slxconvert.NormalizeRelease(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: expected the form R20YYa or R20YYb, e.g. R2023b (any case, R optional)", source, s)
+	}
+	return rel, nil
+}
+
+// promptable reports whether a run without a release should ask for one:
+// a file was named, e.g. by dropping it onto the executable, and someone
+// is at the console, i.e. stdin is not the input or a file list and both
+// it and stderr are terminals (stdin alone may be /dev/null).
+func promptable() bool {
+	return flag.NArg() > 0 && flag.Arg(0) != "-" && *fromFile != "-" && isTerminal(os.Stdin) && isTerminal(os.Stderr)
+}
+
+// promptRelease lists the supported releases on stderr and reads the
+// user's choice, by number or name, from in.
+func promptRelease(in io.Reader) (string, error) {
+	eprintf("No target release given. Convert to which release?\n")
+	for i, rel := range slxconvert.SupportedReleases {
+		eprintf("  %d) %s\n", i+1, rel)
+	}
+	sc := bufio.NewScanner(in)
+	for {
+		eprintf("Release (1-%d or name, empty to cancel): ", len(slxconvert.SupportedReleases))
+		if !sc.Scan() {
+			eprintf("\n")
+			return "", errors.New("no target release chosen")
+		}
+		answer := strings.TrimSpace(sc.Text())
+		if answer == "" {
+			return "", errors.New("no target release chosen")
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(slxconvert.SupportedReleases) {
+			return slxconvert.SupportedReleases[n-1], nil
+		}
+		if rel, err := slxconvert.NormalizeRelease(answer); err == nil && slxconvert.IsSupportedRelease(rel) {
+			return rel, nil
+		}
+		eprintf("%q is not one of the choices.\n", answer)
+	}
+}
+
+// releaseGiven reports whether any flag selecting the target release was
+// set on the command line.
+func releaseGiven() bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "r", "release", "version-string", "release-from":
+			given = true
+		default:
+			given = given || isReleaseFlag(f.Name)
+		}
+	})
+	return given
+}
+
+// excludedReleases holds the --exclude-release releases; files already at
+// one of them are skipped.
+var excludedReleases = map[string]bool{}
+
+// inputRoot is the tree --output-dir mirrors: the directory being
+// converted, the folder of a single input file, or for --from-file the
+// current directory.
+var inputRoot = "."
+
+// outputPathFor returns the default output path for slx, adding suffix and
+// applying --output-dir if set.
+func outputPathFor(slx, suffix string) string {
+	// the suffix goes before both extensions of model.slx.gz
+	base := slxconvert.Unwrapped(slx)
+	ext := filepath.Ext(base)
+	out := strings.TrimSuffix(base, ext) + suffix + ext + slx[len(base):]
+	if *outputDir == "" {
+		return out
+	}
+	return filepath.Join(*outputDir, treeRel(out))
+}
+
+// treeRel returns path relative to inputRoot, for mirroring the input tree
+// elsewhere, or just its name if it lies outside the tree.
+func treeRel(path string) string {
+	rel, err := filepath.Rel(inputRoot, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return filepath.Base(path)
+	}
+	return rel
+}
+
+// isOutputDir reports whether path is the --output-dir, which a directory
+// scan must not descend into when it sits inside the input tree.
+func isOutputDir(path string) bool {
+	if *outputDir == "" {
+		return false
+	}
+	a, err1 := filepath.Abs(path)
+	b, err2 := filepath.Abs(*outputDir)
+	return err1 == nil && err2 == nil && a == b
+}
+
+// parseCompressionLevel converts a --compression-level value into a flate
+// level, with store reporting that entries should not be compressed.
+func parseCompressionLevel(s string) (level int, store bool, err error) {
+	switch strings.ToLower(s) {
+	case "", "default":
+		return 0, false, nil
+	case "none", "store", "0":
+		return 0, true, nil
+	case "fast":
+		return flate.BestSpeed, false, nil
+	case "best":
+		return flate.BestCompression, false, nil
+	}
+	level, err = strconv.Atoi(s)
+	if err != nil || level < 1 || level > 9 {
+		return 0, false, fmt.Errorf("invalid --compression-level %q: expected 0-9, none, fast, best or default", s)
+	}
+	return level, false, nil
+}
+
+// splitTags splits a comma-separated --tags or --text-tags value.
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseSize converts a size such as 1500, 512K, 64M or 2G (powers of 1024)
+// into bytes. -1 means no limit and "" zero, i.e. the default.
+func parseSize(flagName, s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if s == "-1" {
+		return -1, nil
+	}
+	mult := int64(1)
+	if i := len(s) - 1; i > 0 {
+		switch s[i] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:i]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid --%s %q: expected a positive size such as 512M or 2G, or -1 for no limit", flagName, s)
+	}
+	return n * mult, nil
+}
+
+// conversionOptions builds the library options from the command-line flags.
+func conversionOptions() slxconvert.Options {
+	opts := slxconvert.Options{
+		DryRun:           *dryRun,
+		InMemory:         *inMemory,
+		PreserveMtime:    *preserveMtime,
+		Force:            *force,
+		Backup:           *backup,
+		CompressionLevel: compressionLevel,
+		Store:            storeEntries,
+		Verify:           *verify,
+		DowngradeOnly:    *downgradeOnly,
+		UpgradeOnly:      *upgradeOnly,
+		Checksum:         *checksum,
+		Retries:          *retries,
+		WorkDir:          *workDir,
+		KeepWorkDir:      *keepWorkDir,
+		StripExtra:       *stripExtra,
+		SkipTags:         skipTags,
+		ForceWritable:    *forceWritable,
+		MaxEntrySize:     maxEntrySize,
+		MaxTotalSize:     maxTotalSize,
+	}
+	opts.ExtraTags = splitTags(*extraTags)
+	opts.TextTags = splitTags(*textTags)
+	if verbose {
+		opts.Logger = log.New(os.Stderr, "[verbose] ", 0)
+	}
+	return opts
+}
+
+func convertSLX(slx, outSLX string) (slxconvert.Result, error) {
+	ctx, cancel := fileContext()
+	defer cancel()
+	var res slxconvert.Result
+	var err error
+	if *normalize {
+		res, err = slxconvert.NormalizeContext(ctx, slx, outSLX, conversionOptions())
+	} else {
+		res, err = slxconvert.ConvertContext(ctx, slx, outSLX, selectedRelease, conversionOptions())
+	}
+	if res.WorkDir != "" {
+		resultf("Kept work directory for %s: %s\n", slx, res.WorkDir)
+	}
+	return res, timeoutError(err)
+}
+
+// fileContext returns the context a single file is converted under,
+// bounded by --timeout when set.
+func fileContext() (context.Context, context.CancelFunc) {
+	if *timeout > 0 {
+		return context.WithTimeout(context.Background(), *timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// timeoutError rewords a deadline error from fileContext.
+func timeoutError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s (--timeout)", *timeout)
+	}
+	return err
+}
+
+// cleanupOnInterrupt removes any active work directories when the process
+// receives an interrupt or termination signal, then exits.
+func cleanupOnInterrupt() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		slxconvert.CleanupWorkDirs()
+		os.Exit(130)
+	}()
+}
+
+// outputMu serializes progress output from concurrent workers.
+var outputMu sync.Mutex
+
+// stdoutIsData is set when the converted archive itself is written to stdout.
+var stdoutIsData bool
+
+// printPaths is set with --stdout-summary-only while the files being
+// written are the run's real outputs, rather than the contents of a
+// --in-zip batch.
+var printPaths bool
+
+// batchDir and batchZip are set while the contents of a --in-zip batch are
+// converted: batchDir is the temporary directory it was extracted to and
+// batchZip the batch itself. --exec then runs on the repackaged batch
+// rather than its temporary files, and paths are reported by shownPath.
+var batchDir, batchZip string
+
+// shownPath returns path as it is reported: inside a --in-zip batch, as a
+// path below the batch rather than its extraction directory.
+func shownPath(path string) string {
+	if batchDir == "" {
+		return path
+	}
+	rel, err := filepath.Rel(batchDir, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return path
+	}
+	return filepath.Join(batchZip, rel)
+}
+
+// progressLine is the "[N/total]" counter currently drawn on the terminal,
+// which other output must clear and redraw around. Guarded by outputMu.
+var progressLine string
+
+// printf writes progress output without interleaving with other workers.
+// With --json (or an archive on stdout), progress goes to stderr so stdout
+// carries only the summary. Nothing is printed with --quiet.
+func printf(format string, args ...any) {
+	if quiet {
+		return
+	}
+	resultf(format, args...)
+}
+
+// resultf is printf for output the user explicitly asked for, such as
+// --detect lines, which --quiet does not suppress.
+func resultf(format string, args ...any) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	clearProgressLocked()
+	defer redrawProgressLocked()
+	if *jsonOutput || stdoutIsData || *pathsOnly {
+		fmt.Fprintf(os.Stderr, format, args...)
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+// eprintf writes to stderr without interleaving with other workers.
+func eprintf(format string, args ...any) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	clearProgressLocked()
+	defer redrawProgressLocked()
+	fmt.Fprintf(os.Stderr, format, args...)
+}
+
+// printCreated reports that the run wrote path. With --stdout-summary-only
+// the bare path is all that goes to stdout, so a build rule can capture it.
+func printCreated(path string) {
+	if !*pathsOnly {
+		printf("Created: %s\n", shownPath(path))
+		return
+	}
+	if !printPaths {
+		return
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	clearProgressLocked()
+	defer redrawProgressLocked()
+	fmt.Println(path)
+}
+
+// warnf writes a non-fatal warning to stderr unless --quiet is set.
+func warnf(format string, args ...any) {
+	if quiet {
+		return
+	}
+	eprintf(format, args...)
+}
+
+func clearProgressLocked() {
+	if progressLine != "" {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+func redrawProgressLocked() {
+	if progressLine != "" {
+		fmt.Fprint(os.Stderr, progressLine)
+	}
+}
+
+// isTerminal reports whether f is attached to a character device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressCounter reports how many files of a directory run have finished.
+// On a terminal it redraws a single "[N/total]" line on stderr; otherwise
+// it prints a summary line roughly every tenth of the run.
+type progressCounter struct {
+	done, total, step int
+	tty               bool
+}
+
+func newProgressCounter(total int) *progressCounter {
+	step := total / 10
+	if step < 1 {
+		step = 1
+	}
+	return &progressCounter{total: total, step: step, tty: isTerminal(os.Stderr)}
+}
+
+// advance records one more finished file.
+func (p *progressCounter) advance() {
+	if quiet {
+		return
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	p.done++
+	text := fmt.Sprintf("[%d/%d]", p.done, p.total)
+	if p.tty {
+		clearProgressLocked()
+		progressLine = text
+		redrawProgressLocked()
+	} else if p.done%p.step == 0 || p.done == p.total {
+		fmt.Fprintf(os.Stderr, "Progress: %s files done\n", text)
+	}
+}
+
+// finish ends the terminal progress line.
+func (p *progressCounter) finish() {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if progressLine != "" {
+		fmt.Fprintln(os.Stderr)
+		progressLine = ""
+	}
+}
+
+// fileResult is the per-file entry of the --json summary.
+type fileResult struct {
+	Input           string                 `json:"input"`
+	Output          string                 `json:"output,omitempty"`
+	OriginalRelease string                 `json:"originalRelease,omitempty"`
+	TargetRelease   string                 `json:"targetRelease,omitempty"`
+	TagsModified    []slxconvert.TagChange `json:"tagsModified,omitempty"`
+	Skipped         bool                   `json:"skipped,omitempty"`
+	SkipReason      string                 `json:"skipReason,omitempty"`
+	BadMetadata     []string               `json:"badMetadata,omitempty"`
+	MetadataEntries []string               `json:"metadataEntries,omitempty"`
+	InputSHA256     string                 `json:"inputSha256,omitempty"`
+	OutputSHA256    string                 `json:"outputSha256,omitempty"`
+	WorkDir         string                 `json:"workDir,omitempty"`
+	NonASCIINames   []string               `json:"nonAsciiNames,omitempty"`
+	ChangeReport    string                 `json:"changeReport,omitempty"`
+	Seconds         float64                `json:"seconds,omitempty"`
+	Backup          string                 `json:"backup,omitempty"`
+	ExecExit        *int                   `json:"execExit,omitempty"`
+
+	Incompatibilities []slxconvert.Incompatibility `json:"incompatibilities,omitempty"`
+	Error             string                       `json:"error,omitempty"`
+}
+
+var (
+	resultsMu sync.Mutex
+	results   []fileResult
+)
+
+// skipTooSmall is the SkipReason of a file below --min-size, which is
+// never opened.
+const skipTooSmall = "smaller than --min-size"
+
+// recordResult appends r to the run summary.
+func recordResult(r fileResult) {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	results = append(results, r)
+}
+
+// markFailed records msg as the error of the latest result for input, for
+// a file that converted but failed a check made afterwards.
+func markFailed(input, msg string) {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	for i := len(results) - 1; i >= 0; i-- {
+		if results[i].Input == input {
+			results[i].Error = msg
+			return
+		}
+	}
+}
+
+// runStart is when the run began, for the --summary footer.
+var runStart = time.Now()
+
+// finishRun prints the end-of-run reports: the --summary footer and the
+// --json summary.
+func finishRun() {
+	printReleaseReport()
+	printSummary()
+	writeManifest()
+	writeJSONSummary()
+}
+
+// printReleaseReport prints the --report-releases histogram of the
+// recorded results, oldest release first, e.g. "R2023b: 120, R2024a: 45,
+// unknown: 3". Files whose release could not be read count as unknown.
+func printReleaseReport() {
+	if !*reportReleases {
+		return
+	}
+	resultsMu.Lock()
+	counts := make(map[string]int)
+	for _, r := range results {
+		if r.SkipReason == skipTooSmall {
+			continue // never looked at
+		}
+		counts[r.OriginalRelease]++
+	}
+	resultsMu.Unlock()
+	if len(counts) == 0 {
+		resultf("No archives found\n")
+		return
+	}
+
+	unknown := counts[""]
+	delete(counts, "")
+	releases := make([]string, 0, len(counts))
+	for rel := range counts {
+		releases = append(releases, rel)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if c, err := slxconvert.CompareReleases(releases[i], releases[j]); err == nil {
+			return c < 0
+		}
+		return releases[i] < releases[j]
+	})
+	var parts []string
+	for _, rel := range releases {
+		parts = append(parts, fmt.Sprintf("%s: %d", rel, counts[rel]))
+	}
+	if unknown > 0 {
+		parts = append(parts, fmt.Sprintf("unknown: %d", unknown))
+	}
+	resultf("%s\n", strings.Join(parts, ", "))
+}
+
+// tally counts rs by outcome: done, skipped or failed.
+func tally(rs []fileResult) (done, skipped, failed int) {
+	for _, r := range rs {
+		switch {
+		case r.Skipped:
+			skipped++
+		case r.Error != "":
+			failed++
+		default:
+			done++
+		}
+	}
+	return done, skipped, failed
+}
+
+// printSummary prints a one-line count of the recorded results when
+// --summary is set, e.g. "Converted 42 file(s) to R2023b (3 skipped,
+// 1 error(s)) in 12.4s".
+func printSummary() {
+	if !*summary {
+		return
+	}
+	resultsMu.Lock()
+	done, skipped, failed := tally(results)
+	resultsMu.Unlock()
+
+	what := "Converted"
+	switch {
+	case detectMode || *checkOnly:
+		what = "Checked"
+	case *dryRun && *normalize:
+		what = "Would normalize"
+	case *dryRun:
+		what = "Would convert"
+	case *normalize:
+		what = "Normalized"
+	}
+	target := ""
+	if selectedRelease != "" && !detectMode {
+		target = " to " + selectedRelease
+	}
+	resultf("%s %d file(s)%s (%d skipped, %d error(s)) in %.1fs\n", what, done, target, skipped, failed, time.Since(runStart).Seconds())
+}
+
+// writeJSONSummary prints the collected results to stdout when --json is set.
+func writeJSONSummary() {
+	if !*jsonOutput {
+		return
+	}
+	out := results
+	if out == nil {
+		out = []fileResult{}
+	}
+	// with --jobs > 1 results arrive in completion order; report them in
+	// a stable one so that summaries of identical runs compare equal
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Input < out[j].Input })
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// warnNested warns when the metadata of archive was not at its root,
+// which usually means a third-party tool re-zipped it inside a folder.
+func warnNested(archive string, entries []string) {
+	nested := 0
+	for _, e := range entries {
+		if !strings.HasPrefix(e, "metadata/") {
+			nested++
+		}
+	}
+	if nested > 0 {
+		warnf("Warning: %s: %d of %d metadata file(s) found below a nested folder (%s)\n", archive, nested, len(entries), path.Dir(path.Dir(entries[0])))
+	}
+}
+
+// warnNonASCII warns about entries whose names MATLAB may not read back
+// correctly, since the output carries them without the UTF-8 flag.
+func warnNonASCII(archive string, names []string) {
+	for _, name := range names {
+		warnf("Warning: %s: entry name %q is not ASCII; MATLAB may show it garbled or fail to open the file\n", archive, name)
+	}
+}
+
+// printChecksums prints the digests recorded with --checksum in the
+// two-space format of sha256sum.
+func printChecksums(conv slxconvert.Result) {
+	if conv.InputSHA256 != "" {
+		printf("sha256 %s  %s (input)\n", conv.InputSHA256, shownPath(conv.Input))
+	}
+	if conv.OutputSHA256 != "" {
+		printf("sha256 %s  %s (output)\n", conv.OutputSHA256, shownPath(conv.Output))
+	}
+}
+
+// processFile converts (or, in detect mode, inspects) a single archive,
+// writing the result to outSLX and recording it for the run summary.
+func processFile(path, outSLX string) error {
+	if *printTreeFlag {
+		return printTree(path)
+	}
+	if detectMode {
+		return printDetected(path)
+	}
+	if *checkOnly {
+		return printCompatibility(path)
+	}
+
+	name := shownPath(path)
+	res := fileResult{Input: name, TargetRelease: selectedRelease}
+	if *normalize && slxconvert.IsMDL(path) {
+		// a text model has no zip structure to rewrite
+		printf("%s: text model, nothing to normalize, skipped\n", name)
+		res.Skipped = true
+		res.SkipReason = "text model"
+		recordResult(res)
+		return nil
+	}
+	if *noClobber && !*force && !*dryRun && filepath.Clean(outSLX) == filepath.Clean(path) {
+		err := fmt.Errorf("refusing to overwrite input %s (--no-clobber); use -o or --suffix to write elsewhere, or --force to overwrite", path)
+		res.Error = err.Error()
+		recordResult(res)
+		return err
+	}
+	if len(excludedReleases) > 0 {
+		// a file that cannot be read is left for the conversion to report
+		if found, err := slxconvert.Detect(path); err == nil {
+			if rel := slxconvert.PrimaryRelease(found); excludedReleases[rel] {
+				printf("%s: at %s (--exclude-release), skipped\n", name, rel)
+				res.OriginalRelease = rel
+				res.Skipped = true
+				res.SkipReason = "excluded release"
+				recordResult(res)
+				return nil
+			}
+		}
+	}
+	if *parallelSafe && !*dryRun {
+		unlock, err := slxconvert.LockFile(path, *staleLock)
+		if errors.Is(err, slxconvert.ErrLocked) {
+			printf("%s: being converted by another process, skipped\n", name)
+			res.Skipped = true
+			res.SkipReason = "locked by another process"
+			recordResult(res)
+			return nil
+		}
+		if err != nil {
+			res.Error = err.Error()
+			recordResult(res)
+			return err
+		}
+		defer unlock()
+	}
+	if *outputDir != "" && !*dryRun {
+		if err := os.MkdirAll(filepath.Dir(outSLX), 0o755); err != nil {
+			res.Error = err.Error()
+			recordResult(res)
+			return err
+		}
+	}
+	start := time.Now()
+	conv, err := convertSLX(path, outSLX)
+	res.OriginalRelease = conv.OriginalRelease
+	if *timings {
+		elapsed := time.Since(start)
+		res.Seconds = elapsed.Seconds()
+		resultf("%s: took %s\n", name, elapsed.Round(time.Millisecond))
+	}
+	res.WorkDir = conv.WorkDir
+	if err == nil && *emitChanges != "" {
+		res.ChangeReport, err = writeChangeReport(path, conv.OriginalRelease, conv.Changes)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		recordResult(res)
+		return err
+	}
+	res.Output = shownPath(conv.Output)
+	res.TagsModified = conv.Changes
+	res.Skipped = conv.Skipped
+	res.InputSHA256 = conv.InputSHA256
+	res.OutputSHA256 = conv.OutputSHA256
+	res.Backup = conv.Backup
+	res.MetadataEntries = conv.MetadataEntries
+	warnNested(name, conv.MetadataEntries)
+	res.NonASCIINames = conv.NonASCIINames
+	warnNonASCII(name, conv.NonASCIINames)
+	for _, bad := range conv.BadMetadata {
+		res.BadMetadata = append(res.BadMetadata, bad.Error())
+		warnf("Warning: %s: skipped unreadable %s\n", name, bad)
+	}
+	printChecksums(conv)
+	if conv.Skipped {
+		res.SkipReason = "already at the target release"
+		recordResult(res)
+		printf("%s: already at %s, skipped\n", name, selectedRelease)
+		if *pathsOnly {
+			// the input already is the result
+			printCreated(conv.Output)
+		}
+		return nil
+	}
+	switch {
+	case *normalize:
+		printf("%s: rewrote the zip structure, release %s kept\n", name, res.OriginalRelease)
+	case len(conv.Changes) == 0:
+		warnf("Warning: %s: no version tags were updated (already at %s)\n", name, selectedRelease)
+	default:
+		reportSummary(name, res.OriginalRelease, conv.Changes)
+	}
+	if !*normalize {
+		for _, f := range slxconvert.DowngradeRisks(res.OriginalRelease, selectedRelease, slxconvert.KnownFeatures) {
+			warnf("Warning: %s: target %s predates the %s introduced in %s\n", name, selectedRelease, f.Name, f.Introduced)
+		}
+	}
+	if *diffMode {
+		printDiff(name, conv.Changes)
+	}
+	if *dryRun {
+		if !*diffMode {
+			reportChanges(conv.Changes)
+		}
+		printf("Would write: %s\n", res.Output)
+		if res.ChangeReport != "" {
+			printf("Change report: %s\n", res.ChangeReport)
+		}
+	} else {
+		printCreated(conv.Output)
+		if *execHook != "" && batchDir == "" {
+			var exit int
+			exit, err = runHook(conv.Output)
+			res.ExecExit = &exit
+			if err != nil {
+				res.Error = err.Error()
+			}
+		}
+	}
+	recordResult(res)
+	return err
+}
+
+// runHook runs the --exec command for the converted file output and
+// returns its exit status. The command is split into words and run
+// without a shell; {} in any word is replaced by output, which is
+// appended as the last argument if no word contains it. Its output is
+// printed once it finishes so that parallel workers do not interleave.
+func runHook(output string) (int, error) {
+	args := strings.Fields(*execHook)
+	placed := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{}") {
+			args[i] = strings.ReplaceAll(arg, "{}", output)
+			placed = true
+		}
+	}
+	if !placed {
+		args = append(args, output)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if stdout.Len() > 0 {
+		resultf("%s", stdout.String())
+	}
+	if stderr.Len() > 0 {
+		eprintf("%s", stderr.String())
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), fmt.Errorf("--exec %s exited with status %d", args[0], exitErr.ExitCode())
+	case err != nil:
+		return -1, fmt.Errorf("--exec: %w", err)
+	}
+	return 0, nil
+}
+
+// processStream converts a single archive where the input and/or output
+// is "-", meaning stdin and stdout respectively.
+func processStream(in, out string) error {
+	res := fileResult{Input: in, Output: out, TargetRelease: selectedRelease}
+
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			res.Error = err.Error()
+			recordResult(res)
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	ctx, cancel := fileContext()
+	defer cancel()
+	var conv slxconvert.Result
+	convert := func(w io.Writer) error {
+		var err error
+		conv, err = slxconvert.ConvertStreamContext(ctx, r, w, selectedRelease, conversionOptions())
+		return err
+	}
+
+	toFile := out != "-" && !*dryRun
+	var err error
+	switch {
+	case out == "-":
+		// stdout carries the archive, so progress must go elsewhere
+		stdoutIsData = true
+		err = convert(os.Stdout)
+	case toFile:
+		if _, statErr := os.Stat(out); statErr == nil && !*force {
+			err = fmt.Errorf("output %s already exists (use --force to overwrite)", out)
+		} else {
+			err = slxconvert.WriteAtomic(out, convert)
+		}
+	default:
+		err = convert(io.Discard)
+	}
+	err = timeoutError(err)
+	if err != nil {
+		res.Error = err.Error()
+		recordResult(res)
+		return err
+	}
+	res.OriginalRelease = conv.OriginalRelease
+	res.TagsModified = conv.Changes
+	res.InputSHA256 = conv.InputSHA256
+	res.OutputSHA256 = conv.OutputSHA256
+	res.MetadataEntries = conv.MetadataEntries
+	warnNested(in, conv.MetadataEntries)
+	res.NonASCIINames = conv.NonASCIINames
+	warnNonASCII(in, conv.NonASCIINames)
+	for _, bad := range conv.BadMetadata {
+		res.BadMetadata = append(res.BadMetadata, bad.Error())
+		warnf("Warning: %s: skipped unreadable %s\n", in, bad)
+	}
+	recordResult(res)
+	conv.Input, conv.Output = in, out
+	printChecksums(conv)
+	if len(conv.Changes) > 0 {
+		reportSummary(in, conv.OriginalRelease, conv.Changes)
+	}
 
-func updateVersions(xmlPath string, updates map[string]string) error {
-	doc := etree.NewDocument()
-	if err := doc.ReadFromFile(xmlPath); err != nil {
-		return err
+	if *diffMode {
+		printDiff(in, conv.Changes)
 	}
-	modified := false
-	for tag, val := range updates {
-		for _, el := range doc.FindElements("//" + tag) {
-			if el.Text() != val {
-				el.SetText(val)
-				modified = true
-			}
+	if *dryRun {
+		if !*diffMode {
+			reportChanges(conv.Changes)
 		}
+		return nil
 	}
-	if modified {
-		return doc.WriteToFile(xmlPath)
+	if toFile {
+		printCreated(out)
 	}
 	return nil
 }
 
-func unzip(src, dest string) error {
-	r, err := zip.OpenReader(src)
+// junkPatterns match editor lock files, backups and synced-folder
+// artifacts that sit next to real models but are not archives themselves.
+// Dotfiles, including macOS ._ resource forks and .~lock.*# files, are
+// caught separately by isHidden.
+var junkPatterns = []string{"~$*", "*~", "__MACOSX"}
+
+// isJunk reports whether a directory scan should pass over name unless
+// --include-hidden is set.
+func isJunk(name string) bool {
+	if *includeHidden {
+		return false
+	}
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range junkPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// collectFiles returns every convertible archive below dir, which sits
+// depth levels beneath the directory the scan started from. Symlinks are
+// skipped unless --follow-symlinks is set, in which case visited holds the
+// resolved paths already seen so that link loops and files reachable by
+// several routes are only scanned once.
+func collectFiles(dir string, depth int, visited map[string]bool) ([]string, error) {
+	// ReadDir sorts entries by name, so the walk order is the same on
+	// every file system
+	files, err := os.ReadDir(dir)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer r.Close()
 
-	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
+	var paths []string
+	for _, file := range files {
+		if isJunk(file.Name()) {
 			continue
 		}
-		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return err
-		}
-		rc, err := f.Open()
-		if err != nil {
-			return err
-		}
-		defer rc.Close()
-		out, err := os.Create(fpath)
-		if err != nil {
-			return err
+		path := filepath.Join(dir, file.Name())
+
+		isDir := file.IsDir()
+		if file.Type()&os.ModeSymlink != 0 {
+			if !*followSymlinks {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				continue // dangling link
+			}
+			isDir = info.IsDir()
 		}
-		defer out.Close()
-		if _, err := io.Copy(out, rc); err != nil {
-			return err
+
+		if isDir {
+			if isOutputDir(path) {
+				continue
+			}
+			if slxconvert.IsWorkDirName(file.Name()) {
+				// left behind by a crashed run; its contents are not models
+				// of the tree, even if a --in-zip batch held some
+				if *cleanWorkDirs && *dryRun {
+					printf("Would remove leftover work directory %s\n", path)
+				} else if *cleanWorkDirs {
+					if err := os.RemoveAll(path); err != nil {
+						return nil, err
+					}
+					printf("Removed leftover work directory %s\n", path)
+				} else {
+					warnf("Warning: skipping %s, which looks like a leftover work directory (remove it or use --clean-workdirs)\n", path)
+				}
+				continue
+			}
+			if *maxDepth >= 0 && depth >= *maxDepth {
+				continue
+			}
+			if *followSymlinks && !firstVisit(visited, path) {
+				continue
+			}
+			// Recursively process subdirectories
+			sub, err := collectFiles(path, depth+1, visited)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, sub...)
+		} else {
+			if isArchiveExt(file.Name()) && wantedType(file.Name()) && (!*followSymlinks || firstVisit(visited, path)) {
+				paths = append(paths, path)
+			}
 		}
 	}
-	return nil
+	return paths, nil
 }
 
-func zipDir(src, dest string) error {
-	zf, err := os.Create(dest)
+// firstVisit resolves path and records it in visited, reporting whether it
+// had not been seen before.
+func firstVisit(visited map[string]bool, path string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
 	if err != nil {
-		return err
+		return false
+	}
+	if visited[resolved] {
+		return false
 	}
-	defer zf.Close()
+	visited[resolved] = true
+	return true
+}
 
-	// Create a new zip writer
-	zw := zip.NewWriter(zf)
-	defer zw.Close()
+// stringList is a flag.Value that collects every occurrence of a repeated flag.
+type stringList []string
 
-	// Use standard Deflate compression
-	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-		return flate.NewWriter(out, flate.DefaultCompression)
-	})
+func (l *stringList) String() string { return strings.Join(*l, ",") }
 
-	// Don't use UTF-8 flag for file names
-	zw.SetComment("") // Empty comment to avoid UTF-8 flag
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
 
-	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return err
+// includePatterns and excludePatterns filter directory scans by glob.
+var includePatterns, excludePatterns stringList
+
+// skipTags are the --skip-tag FILE:TAG exemptions, see Options.SkipTags.
+var skipTags stringList
+
+// matchesAny reports whether rel (a slash-separated path relative to the
+// scanned directory) matches one of patterns. A pattern may match the
+// whole path, the file name, or any leading directory, so "test" or
+// "third_party/*" exclude everything beneath those folders.
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+		for p := rel; p != "."; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
 		}
+	}
+	return false
+}
 
-		rel, err := filepath.Rel(src, path)
+// filterPaths applies --include and --exclude to the files found under root.
+func filterPaths(root string, paths []string) []string {
+	if len(includePatterns) == 0 && len(excludePatterns) == 0 {
+		return paths
+	}
+	var kept []string
+	for _, p := range paths {
+		rel, err := filepath.Rel(root, p)
 		if err != nil {
-			return err
+			rel = p
+		}
+		rel = filepath.ToSlash(rel)
+		if len(includePatterns) > 0 && !matchesAny(includePatterns, rel) {
+			continue
+		}
+		if matchesAny(excludePatterns, rel) {
+			continue
 		}
+		kept = append(kept, p)
+	}
+	return kept
+}
 
-		// Convert Windows backslashes to forward slashes
-		rel = strings.ReplaceAll(rel, "\\", "/")
+// processDirectory converts the archives found in dir, naming their
+// outputs with suffix.
+func processDirectory(dir, suffix string) error {
+	inputRoot = dir
+	visited := make(map[string]bool)
+	firstVisit(visited, dir)
+	paths, err := collectFiles(dir, 0, visited)
+	if err != nil {
+		return err
+	}
+	return processPaths(filterPaths(dir, paths), suffix)
+}
 
-		// Create file header without UTF-8 flag
-		header := &zip.FileHeader{
-			Name:     rel,
-			Method:   zip.Deflate,
-			Modified: info.ModTime(),
+// readFileList reads the newline-separated paths in the manifest file
+// list ("-" for stdin), skipping # comments. Blank lines separate the
+// paths into groups, which --linked converts as linked sets.
+func readFileList(list string) ([][]string, error) {
+	var data []byte
+	var err error
+	if list == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(list)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var groups [][]string
+	var group []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "" {
+			if len(group) > 0 {
+				groups = append(groups, group)
+				group = nil
+			}
+			continue
 		}
+		group = append(group, line)
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
 
-		// Clear UTF-8 flag - crucial for MATLAB compatibility
-		header.Flags &= ^uint16(1 << 11)
+// processList converts the groups of a --from-file list: each group is a
+// linked set with --linked, otherwise every path is converted on its own.
+func processList(groups [][]string) error {
+	if *linked {
+		return processUnits(groups, *outputSuffix)
+	}
+	var paths []string
+	for _, g := range groups {
+		paths = append(paths, g...)
+	}
+	return processUnits(singles(paths), *outputSuffix)
+}
 
-		w, err := zw.CreateHeader(header)
-		if err != nil {
-			return err
+// singles makes every path a unit of its own.
+func singles(paths []string) [][]string {
+	units := make([][]string, len(paths))
+	for i, p := range paths {
+		units[i] = []string{p}
+	}
+	return units
+}
+
+// groupByDir splits paths into one group per folder, the linked sets of a
+// --linked directory run.
+func groupByDir(paths []string) [][]string {
+	var groups [][]string
+	index := make(map[string]int)
+	for _, p := range paths {
+		dir := filepath.Dir(p)
+		i, ok := index[dir]
+		if !ok {
+			i = len(groups)
+			index[dir] = i
+			groups = append(groups, nil)
 		}
+		groups[i] = append(groups[i], p)
+	}
+	return groups
+}
 
-		f, err := os.Open(path)
+// processSet converts the members of a linked set, such as a model and
+// the dictionaries it references, as one unit: nothing is converted unless
+// every member can be read, and the set fails unless every output ends up
+// at selectedRelease. Members at an --exclude-release are skipped, and
+// left out of that check. A set that fails as a whole records every member
+// it names as failed.
+func processSet(set []string, suffix string) error {
+	excluded := make(map[string]bool)
+	for _, path := range set {
+		found, err := slxconvert.Detect(path)
+		rel := slxconvert.PrimaryRelease(found)
+		if err == nil && rel == "" {
+			err = errors.New("no release found")
+		}
 		if err != nil {
+			err = fmt.Errorf("%s: %w; no member converted", shownPath(path), err)
+			for _, member := range set {
+				recordResult(fileResult{Input: shownPath(member), TargetRelease: selectedRelease, Error: err.Error()})
+			}
 			return err
 		}
-		defer f.Close()
+		if excludedReleases[rel] {
+			excluded[path] = true
+		}
+	}
+	var errs []string
+	for _, path := range set {
+		if !detectMode && !*checkOnly {
+			printf("Processing: %s\n", shownPath(path))
+		}
+		if err := processFile(path, outputPathFor(path, suffix)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", shownPath(path), err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	if *dryRun || detectMode || *checkOnly || *normalize {
+		return nil
+	}
 
-		_, err = io.Copy(w, f)
-		return err
-	})
+	var mismatched []string
+	for _, path := range set {
+		if excluded[path] {
+			continue
+		}
+		out := outputPathFor(path, suffix)
+		rel := "unknown"
+		if found, err := slxconvert.Detect(out); err == nil && slxconvert.PrimaryRelease(found) != "" {
+			rel = slxconvert.PrimaryRelease(found)
+		}
+		if rel != selectedRelease {
+			msg := fmt.Sprintf("%s is at %s", shownPath(out), rel)
+			mismatched = append(mismatched, msg)
+			markFailed(shownPath(path), msg)
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("members do not all end at %s: %s", selectedRelease, strings.Join(mismatched, ", "))
+	}
+	return nil
+}
 
-	return err
+// tooSmall reports, with a warning, whether path is below --min-size and
+// so cannot be a complete archive, typically because it is still being
+// downloaded or synced. Such files are skipped rather than failed.
+func tooSmall(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() >= *minSize {
+		return false // a stat error is reported by processFile
+	}
+	warnf("Warning: %s: skipped, only %d bytes (--min-size %d); incomplete download?\n", shownPath(path), info.Size(), *minSize)
+	recordResult(fileResult{Input: shownPath(path), Skipped: true, SkipReason: skipTooSmall})
+	return true
 }
 
-func convertSLX(slx string) (string, error) {
-	base := strings.TrimSuffix(slx, filepath.Ext(slx))
-	outSLX := base + filepath.Ext(slx)
+// processPaths converts paths with --jobs workers, see processUnits. With
+// --linked the files of each folder form a linked set.
+func processPaths(paths []string, suffix string) error {
+	if *linked {
+		return processUnits(groupByDir(paths), suffix)
+	}
+	return processUnits(singles(paths), suffix)
+}
 
-	workDir := base + "_unzipped"
+// processUnits converts units with --jobs workers, reporting each file
+// and a final count. Outputs are named by outputPathFor with suffix. A
+// unit is a single file or, with more than one member, a linked set
+// converted by processSet. A failed unit is handled
+// according to --on-error: "skip" carries on and still succeeds, "stop"
+// ends the run at once and "collect" carries on, then lists every failure
+// and returns an error.
+func processUnits(units [][]string, suffix string) error {
+	n := *jobs
+	if n < 1 {
+		n = 1
+	}
 
-	os.RemoveAll(workDir)
-	if err := os.MkdirAll(workDir, os.ModePerm); err != nil {
-		return "", err
+	total := 0
+	for _, u := range units {
+		total += len(u)
 	}
-	if err := unzip(slx, workDir); err != nil {
-		return "", err
+	resultsMu.Lock()
+	first := len(results)
+	resultsMu.Unlock()
+	var errorsMu sync.Mutex
+	var fileErrors []string
+	progress := newProgressCounter(total)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	fail := func(what string, err error) {
+		eprintf("Error processing %s: %v\n", what, err)
+		errorsMu.Lock()
+		fileErrors = append(fileErrors, fmt.Sprintf("%s: %v", what, err))
+		errorsMu.Unlock()
+		if *onError == "stop" {
+			stopOnce.Do(func() { close(stop) })
+		}
+	}
+
+	queue := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for unit := range queue {
+				if len(unit) > 1 {
+					// members below --min-size are left out, as single
+					// files would be
+					var members, names []string
+					for _, path := range unit {
+						if tooSmall(path) {
+							progress.advance()
+						} else {
+							members = append(members, path)
+							names = append(names, shownPath(path))
+						}
+					}
+					if err := processSet(members, suffix); err != nil {
+						fail("linked set "+strings.Join(names, ", "), err)
+					}
+					for range members {
+						progress.advance()
+					}
+					continue
+				}
+				path := unit[0]
+				if tooSmall(path) {
+					progress.advance()
+					continue
+				}
+				if !detectMode && !*checkOnly {
+					printf("Processing: %s\n", shownPath(path))
+				}
+				if err := processFile(path, outputPathFor(path, suffix)); err != nil {
+					fail(shownPath(path), err)
+				}
+				progress.advance()
+			}
+		}()
+	}
+feed:
+	for _, unit := range units {
+		select {
+		case queue <- unit:
+		case <-stop:
+			break feed
+		}
 	}
+	close(queue)
+	wg.Wait()
+	progress.finish()
 
-	// dynamically apply the chosen release
-	updates := map[string]string{
-		"version":       selectedRelease,
-		"release":       selectedRelease,
-		"matlabRelease": selectedRelease,
+	// counted from the results, as --summary counts them
+	resultsMu.Lock()
+	succeeded, skipped, failed := tally(results[first:])
+	resultsMu.Unlock()
+	if skipped > 0 {
+		printf("Done: %d succeeded, %d failed, %d skipped\n", succeeded, failed, skipped)
+	} else {
+		printf("Done: %d succeeded, %d failed\n", succeeded, failed)
 	}
+	if failed == 0 || *onError == "skip" {
+		return nil
+	}
+	if *onError == "collect" {
+		sort.Strings(fileErrors)
+		eprintf("Failed files:\n  %s\n", strings.Join(fileErrors, "\n  "))
+	}
+	return fmt.Errorf("%d of %d files failed", failed, total)
+}
 
-	xmlFiles := []string{
-		filepath.Join(workDir, "metadata", "mwcoreProperties.xml"),
-		filepath.Join(workDir, "metadata", "mwcorePropertiesReleaseInfo.xml"),
-		filepath.Join(workDir, "metadata", "coreProperties.xml"),
+// processZipBatch converts every model inside the .zip batch path and
+// repackages the result as outZip. The batch is extracted to a temporary
+// directory, converted with processDirectory, and only written back if
+// that succeeds, i.e. every file converted or --on-error=skip is set.
+func processZipBatch(path, outZip string) error {
+	if *noClobber && !*force && !*dryRun && filepath.Clean(outZip) == filepath.Clean(path) {
+		return fmt.Errorf("refusing to overwrite input %s (--no-clobber); use -o or --suffix to write elsewhere, or --force to overwrite", path)
 	}
-	for _, xf := range xmlFiles {
-		if _, err := os.Stat(xf); err == nil {
-			if err := updateVersions(xf, updates); err != nil {
-				return "", err
-			}
+	if filepath.Clean(outZip) != filepath.Clean(path) && !*force && !*dryRun {
+		if _, err := os.Stat(outZip); err == nil {
+			return fmt.Errorf("output %s already exists (use --force to overwrite)", outZip)
 		}
 	}
 
-	if err := zipDir(workDir, outSLX); err != nil {
-		return "", err
+	ctx := context.Background()
+	dir, manifest, cleanup, err := slxconvert.ExtractBatch(ctx, path, conversionOptions())
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
 	}
-	// clean up temporary folder
-	os.RemoveAll(workDir)
-	return outSLX, nil
-}
+	defer cleanup()
+	printf("Extracted %s (%d files)\n", path, len(manifest))
 
-func processDirectory(dir string) error {
-	files, err := os.ReadDir(dir)
+	// --suffix names the repackaged batch; the models inside it are
+	// converted in place
+	printPaths, batchDir, batchZip = false, dir, path
+	err = processDirectory(dir, "")
+	printPaths, batchDir, batchZip = *pathsOnly, "", ""
 	if err != nil {
 		return err
 	}
-
-	for _, file := range files {
-		path := filepath.Join(dir, file.Name())
-
-		if file.IsDir() {
-			// Recursively process subdirectories
-			if err := processDirectory(path); err != nil {
-				return err
-			}
-		} else {
-			ext := strings.ToLower(filepath.Ext(file.Name()))
-			if ext == ".slx" {
-				// Process SLX, SLDD, or MLDATX file
-				fmt.Printf("Processing: %s\n", path)
-				out, err := convertSLX(path)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", path, err)
-					continue // Continue with next file on error
-				}
-				fmt.Println("Created:", out)
-			}
+	if *dryRun || detectMode || *checkOnly {
+		return nil
+	}
+	if err := slxconvert.PackBatch(ctx, dir, outZip, manifest); err != nil {
+		return err
+	}
+	printCreated(outZip)
+	if *execHook != "" {
+		if _, err := runHook(outZip); err != nil {
+			return fmt.Errorf("%s: %w", outZip, err)
 		}
 	}
 	return nil
@@ -211,61 +1801,322 @@ func processDirectory(dir string) error {
 
 func main() {
 	// Define command-line flags
-	recursiveFlag := flag.Bool("d", false, "Process directory recursively")
-	recursiveLongFlag := flag.Bool("directory", false, "Process directory recursively")
+	recursiveFlag := flag.Bool("d", false, "Process subdirectories of a directory too")
+	recursiveLongFlag := flag.Bool("directory", false, "Process subdirectories of a directory too")
+	// -r is the target release, so only the long spelling is offered
+	recursiveAliasFlag := flag.Bool("recursive", false, "Same as -d/--directory")
+	outputFlag := flag.String("o", "", "Output path for single-file conversion")
+	outputLongFlag := flag.String("output", "", "Output path for single-file conversion")
+	verboseFlag := flag.Bool("v", false, "Log each step of the conversion to stderr")
+	verboseLongFlag := flag.Bool("verbose", false, "Log each step of the conversion to stderr")
+	quietFlag := flag.Bool("q", false, "Suppress progress output; only errors are printed")
+	quietLongFlag := flag.Bool("quiet", false, "Suppress progress output; only errors are printed")
+	releaseShortFlag := flag.String("r", "", "Target release, e.g. R2023b")
+	detectFlag := flag.Bool("detect", false, "Print the current release without converting")
+	infoFlag := flag.Bool("info", false, "Print the current release without converting")
+	tmpFlag := flag.String("tmp", "", "Same as --workdir")
+	flag.Var(&includePatterns, "include", "Only process files matching this glob in directory mode (repeatable)")
+	flag.Var(&excludePatterns, "exclude", "Skip files matching this glob in directory mode (repeatable)")
+	flag.Var(&skipTags, "skip-tag", "Leave TAG in metadata FILE alone, as FILE:TAG or just FILE (repeatable)")
 
 	// Custom usage message
 	flag.Usage = func() {
 		prog := filepath.Base(os.Args[0])
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] <input.slx or directory>\n\n", prog)
+		fmt.Fprintf(os.Stderr, "Usage: %s [command] [options] <input.slx, directory, or - for stdin>\n\n", prog)
+		printCommands(prog)
 		fmt.Fprintf(os.Stderr, "Options:\n")
-		fmt.Fprintf(os.Stderr, "  -d, --directory    Process all .slx/.sldd/.mldatx files in directory recursively\n")
-		fmt.Fprintf(os.Stderr, "  --r2022a           Set output to R2023b\n")
-		fmt.Fprintf(os.Stderr, "  --r2022b           Set output to R2024a\n")
-		fmt.Fprintf(os.Stderr, "  --r2023a           Set output to R2024b\n")
-		fmt.Fprintf(os.Stderr, "  --r2023b           Set output to R2023a\n")
-		fmt.Fprintf(os.Stderr, "  --r2024a           Set output to R2022b\n")
-		fmt.Fprintf(os.Stderr, "  --r2024b           Set output to R2022a\n\n")
+		fmt.Fprintf(os.Stderr, "  -d, --directory    Also process subdirectories (a bare directory converts only its top level)\n")
+		fmt.Fprintf(os.Stderr, "  --recursive        Same as -d/--directory (-r is --release)\n")
+		fmt.Fprintf(os.Stderr, "  --from-file LIST   Convert the files listed in LIST, one path per line (# comments; - for stdin)\n")
+		fmt.Fprintf(os.Stderr, "  --linked           Convert each folder, or each blank-line-separated group of a --from-file list, as one\n")
+		fmt.Fprintf(os.Stderr, "                     set (e.g. a model and its .sldd files) and fail it unless all end at the target release\n")
+		fmt.Fprintf(os.Stderr, "  --ext a,b          Also process files with these extensions in directory mode\n")
+		fmt.Fprintf(os.Stderr, "  --types a,b        Only process these file types in directory mode, e.g. slx or sldd (default all)\n")
+		fmt.Fprintf(os.Stderr, "  --include-hidden   Also scan dotfiles and lock/backup files (~$*, *~) in directory mode\n")
+		fmt.Fprintf(os.Stderr, "  --follow-symlinks  Follow symbolic links in directory mode (skipped by default)\n")
+		fmt.Fprintf(os.Stderr, "  --max-depth N      Limit recursion to N levels below the directory (0 = top level only)\n")
+		fmt.Fprintf(os.Stderr, "  --include GLOB     Only process matching files in directory mode (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --exclude GLOB     Skip matching files or folders in directory mode (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -v, --verbose      Log each extracted file, tag match and written entry to stderr\n")
+		fmt.Fprintf(os.Stderr, "  -q, --quiet        Suppress progress output; only errors are printed (overridden by -v)\n")
+		fmt.Fprintf(os.Stderr, "  -o, --output       Write a single-file conversion to this path (- for stdout)\n")
+		fmt.Fprintf(os.Stderr, "  --output-dir DIR   Write outputs under DIR, mirroring the input tree (inputs are untouched)\n")
+		fmt.Fprintf(os.Stderr, "  --suffix           Append suffix to output names instead of overwriting (e.g. _converted)\n")
+		fmt.Fprintf(os.Stderr, "  --force            Overwrite an existing output file\n")
+		fmt.Fprintf(os.Stderr, "  --force-writable   Convert read-only files (e.g. locked VCS checkouts), restoring their permissions after\n")
+		fmt.Fprintf(os.Stderr, "  --no-clobber       Refuse to overwrite the input in place (unless --force)\n")
+		fmt.Fprintf(os.Stderr, "  --backup           Save a copy of the input as <name>.slx.bak before converting\n")
+		fmt.Fprintf(os.Stderr, "  --manifest F       Record each converted file's releases, backup and checksums in F (implies --backup in place)\n")
+		fmt.Fprintf(os.Stderr, "  --rollback F       Restore the originals listed in manifest F and delete their backups (--dry-run to preview)\n")
+		fmt.Fprintf(os.Stderr, "  --detect, --info   Print the current release of each file without converting\n")
+		fmt.Fprintf(os.Stderr, "  --print-tree       List each archive's entries with size, compression method and modified time\n")
+		fmt.Fprintf(os.Stderr, "  --report-releases  Count the releases of every archive below a directory, e.g. \"R2023b: 120, unknown: 3\"\n")
+		fmt.Fprintf(os.Stderr, "  --checksum         Print SHA-256 of each input and output (also added to --json)\n")
+		fmt.Fprintf(os.Stderr, "  --summary          Finish with a line like \"Converted 42 file(s) to R2023b (3 skipped, 1 error(s)) in 12.4s\"\n")
+		fmt.Fprintf(os.Stderr, "  --exec 'CMD {}'    Run CMD after each converted file, {} being the output path (no shell; exit status\n")
+		fmt.Fprintf(os.Stderr, "                     added to --json); a failure counts as the file's error, see --on-error\n")
+		fmt.Fprintf(os.Stderr, "  --timings          Print how long each file took to convert (also added to --json as seconds)\n")
+		fmt.Fprintf(os.Stderr, "  --json             Print a JSON summary to stdout (progress goes to stderr)\n")
+		fmt.Fprintf(os.Stderr, "  --stdout-summary-only  Print just the path of each file produced, one per line (for make); all else to stderr\n")
+		fmt.Fprintf(os.Stderr, "  --on-error P       After a failed file: skip (carry on, exit 0), stop, or collect (default:\n")
+		fmt.Fprintf(os.Stderr, "                     carry on, list the failures at the end and exit non-zero)\n")
+		fmt.Fprintf(os.Stderr, "  --fail-fast        Stop a directory run at the first error (same as --on-error=stop)\n")
+		fmt.Fprintf(os.Stderr, "  --min-size N       Skip files under N bytes in a directory run, e.g. partial downloads (default %d)\n", minZipSize)
+		fmt.Fprintf(os.Stderr, "  --in-zip           Convert the models inside a .zip batch and repackage it (-o or --suffix to write elsewhere)\n")
+		fmt.Fprintf(os.Stderr, "  --jobs N           Convert N files in parallel in directory mode (default: number of CPUs; 1 for a reproducible log)\n")
+		fmt.Fprintf(os.Stderr, "  --parallel-safe    Lock each file while converting; skip files another run has locked\n")
+		fmt.Fprintf(os.Stderr, "  --stale-lock D     Break locks older than D left by crashed runs (default 1h, 0 = never)\n")
+		fmt.Fprintf(os.Stderr, "  --timeout D        Give up on a file after duration D, e.g. 30s or 2m (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --retries N        Retry a file up to N times after transient I/O errors (EIO, ESTALE, timeouts)\n")
+		fmt.Fprintf(os.Stderr, "  --in-memory        Convert in memory even when --compression-level calls for extracting every entry\n")
+		fmt.Fprintf(os.Stderr, "  --workdir, --tmp D Extract to temporary directories under D (default $%s or the OS temp dir)\n", workDirEnv)
+		fmt.Fprintf(os.Stderr, "  --keep-workdir     Leave the extracted files in place for inspection and print where\n")
+		fmt.Fprintf(os.Stderr, "  --clean-workdirs   Delete leftover *_unzipped* work directories found in a directory scan (skipped otherwise)\n")
+		fmt.Fprintf(os.Stderr, "  -r, --release REL  Set output to REL, one of %s\n", strings.Join(slxconvert.SupportedReleases, ", "))
+		fmt.Fprintf(os.Stderr, "                     in any case and with or without the R, e.g. r2023b or 2023b\n")
+		fmt.Fprintf(os.Stderr, "  --r2023b etc.      Deprecated shorthand for --release R2023b\n")
+		fmt.Fprintf(os.Stderr, "                     without any release flag, $%s is used (then the config file)\n", releaseEnv)
+		fmt.Fprintf(os.Stderr, "  --version-string   Set output to an arbitrary release (format R20YY[a|b])\n")
+		fmt.Fprintf(os.Stderr, "  --release-from F   Set output to the release of the reference archive F\n")
+		fmt.Fprintf(os.Stderr, "  --interactive      Ask for the release when none is given (default when a file is named and stdin is a\n")
+		fmt.Fprintf(os.Stderr, "                     terminal, e.g. after dropping a model onto the executable)\n")
+		fmt.Fprintf(os.Stderr, "  --exclude-release a,b  Skip files already at any of these releases, e.g. R2023b,R2024a\n")
+		fmt.Fprintf(os.Stderr, "  --downgrade-only   Refuse to convert a file to a newer release than it has\n")
+		fmt.Fprintf(os.Stderr, "  --upgrade-only     Refuse to convert a file to an older release than it has\n")
+		fmt.Fprintf(os.Stderr, "  --check-only       Report blocks the target release predates, without converting\n")
+		fmt.Fprintf(os.Stderr, "  --compat-table F   Add features from a JSON file to the downgrade warning table\n")
+		fmt.Fprintf(os.Stderr, "  --verify           Reopen each output and check its version tags match the target\n")
+		fmt.Fprintf(os.Stderr, "  --compression-level Deflate level 0-9, or none, fast, best (0/none stores entries uncompressed)\n")
+		fmt.Fprintf(os.Stderr, "                     (recompresses every entry; by default only the metadata is, the rest is copied as it is)\n")
+		fmt.Fprintf(os.Stderr, "  --max-entry-size N Refuse archives with an entry over N bytes uncompressed, e.g. 512M (default 2G, -1 = none)\n")
+		fmt.Fprintf(os.Stderr, "  --max-total-size N Refuse archives over N bytes uncompressed in all, e.g. 4G (default 8G, -1 = none)\n")
+		fmt.Fprintf(os.Stderr, "  --strip-extra      Drop ZIP comments and extra fields instead of copying them from the input\n")
+		fmt.Fprintf(os.Stderr, "  --normalize        Only rezip each archive the way MATLAB expects (no release needed; tags left as they are)\n")
+		fmt.Fprintf(os.Stderr, "  --tags a,b         Also rewrite these element names (added to version, release, matlabRelease)\n")
+		fmt.Fprintf(os.Stderr, "                     use element@attr to rewrite an attribute, e.g. coreProperty@release\n")
+		fmt.Fprintf(os.Stderr, "                     a bare name matches in any namespace; prefix:name or {uri}name only in that one\n")
+		fmt.Fprintf(os.Stderr, "  --text-tags a,b    Elements whose text mentions the release, e.g. a description reading \"saved with\n")
+		fmt.Fprintf(os.Stderr, "                     MATLAB R2024b\"; only the release in them is replaced\n")
+		fmt.Fprintf(os.Stderr, "  --skip-tag F:T     Never rewrite tag T in metadata file F, e.g. coreProperties.xml:version (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "                     a bare F leaves that whole file alone\n")
+		fmt.Fprintf(os.Stderr, "  --config FILE      Read default options from a JSON file (default ./.slxconvert.json)\n")
+		fmt.Fprintf(os.Stderr, "  --list-releases    Print the supported release strings and exit\n")
+		fmt.Fprintf(os.Stderr, "  --selftest         Round-trip a built-in archive and check MATLAB's zip invariants, then exit\n")
+		fmt.Fprintf(os.Stderr, "  --diff             Show each rewritten element as a -/+ diff per metadata file\n")
+		fmt.Fprintf(os.Stderr, "  --dry-run          Report the tags that would change without writing any files\n")
+		fmt.Fprintf(os.Stderr, "  --emit-changes DIR With --dry-run, save a report of each model's planned changes as DIR/<model>.changes.txt\n")
+		fmt.Fprintf(os.Stderr, "  --preserve-mtime   Keep the original modification time (default true; use =false to opt out)\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
-		fmt.Fprintf(os.Stderr, "  %s model.slx                  # Convert a single file\n", prog)
-		fmt.Fprintf(os.Stderr, "  %s data.sldd                  # Convert a single file\n", prog)
-		fmt.Fprintf(os.Stderr, "  %s -d folder_with_archives    # Convert all .slx, .sldd, or .mldatx files in directory\n", prog)
+		fmt.Fprintf(os.Stderr, "  %s -r R2023b model.slx                # Convert a single file to R2023b\n", prog)
+		fmt.Fprintf(os.Stderr, "  %s -r R2024a data.sldd                # Convert a data dictionary to R2024a\n", prog)
+		fmt.Fprintf(os.Stderr, "  %s -r R2023b - < in.slx > out.slx     # Convert from stdin to stdout\n", prog)
+		fmt.Fprintf(os.Stderr, "  %s -r R2024a folder_with_archives     # Convert the archives directly in folder to R2024a\n", prog)
+		fmt.Fprintf(os.Stderr, "  %s -r R2024a -d folder_with_archives  # Convert every archive in folder and below to R2024a\n", prog)
+		fmt.Fprintf(os.Stderr, "  %s detect -d folder_with_archives     # Print the release of every archive in folder and below\n", prog)
+	}
+
+	parseCommandLine()
+	cleanupOnInterrupt()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: config:", err)
+		os.Exit(1)
+	}
+	if cfg != nil {
+		applyConfig(cfg)
 	}
 
-	flag.Parse()
+	// --report-releases is a census built from detect mode's results
+	detectMode = *detectFlag || *infoFlag || *reportReleases || *printTreeFlag
+	verbose = *verboseFlag || *verboseLongFlag
+	// verbose output is a superset of the default, so it wins over quiet
+	quiet = (*quietFlag || *quietLongFlag || *pathsOnly) && !verbose
+	printPaths = *pathsOnly
+	if *pathsOnly && *jsonOutput {
+		fmt.Fprintln(os.Stderr, "Error: --stdout-summary-only and --json both want stdout")
+		os.Exit(1)
+	}
 
-	// ensure exactly one release flag is set
-	count := 0
-	if *r2023b {
-		count++
-		selectedRelease = "R2023b"
+	// a mistyped release should fail before any file is looked at
+	if !*selfTest && !*listReleases && *rollbackPath == "" {
+		selectedRelease, err = resolveRelease(*releaseShortFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			fmt.Fprintf(os.Stderr, "Run %s -h for usage.\n", filepath.Base(os.Args[0]))
+			os.Exit(1)
+		}
 	}
-	if *r2024a {
-		count++
-		selectedRelease = "R2024a"
+
+	if *tmpFlag != "" && *workDir == "" {
+		*workDir = *tmpFlag
 	}
-	if *r2024b {
-		count++
-		selectedRelease = "R2024b"
+	if *workDir == "" {
+		*workDir = os.Getenv(workDirEnv)
 	}
-	if *r2023a {
-		count++
-		selectedRelease = "R2023a"
+	if *outputDir != "" && (*inZip || *outputFlag != "" || *outputLongFlag != "") {
+		fmt.Fprintln(os.Stderr, "Error: --output-dir cannot be combined with -o/--output or --in-zip")
+		os.Exit(1)
 	}
-	if *r2022b {
-		count++
-		selectedRelease = "R2022b"
+	if *keepWorkDir && *inMemory {
+		fmt.Fprintln(os.Stderr, "Error: --keep-workdir needs the on-disk conversion and cannot be combined with --in-memory")
+		os.Exit(1)
 	}
-	if *r2022a {
-		count++
-		selectedRelease = "R2022a"
+	if *workDir != "" {
+		if info, err := os.Stat(*workDir); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Error: work directory %s is not a directory\n", *workDir)
+			os.Exit(1)
+		}
 	}
-	if count != 1 {
-		fmt.Fprintln(os.Stderr, "Error: must specify exactly one of --r2022a, --r2022b, --r2023a, --r2023b, --r2024a, or --r2024b")
-		flag.Usage()
+	for _, pattern := range append(append([]string(nil), includePatterns...), excludePatterns...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid glob pattern %q\n", pattern)
+			os.Exit(1)
+		}
+	}
+
+	for _, ext := range strings.Split(*extraExts, ",") {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			archiveExts = append(archiveExts, ext)
+		}
+	}
+	for _, ext := range strings.Split(*fileTypes, ",") {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		known := false
+		for _, e := range archiveExts {
+			known = known || e == ext
+		}
+		if !known {
+			fmt.Fprintf(os.Stderr, "Error: invalid --types %q: expected some of %s (add types with --ext)\n", strings.TrimPrefix(ext, "."), strings.Join(archiveExts, ", "))
+			os.Exit(1)
+		}
+		if scanTypes == nil {
+			scanTypes = make(map[string]bool)
+		}
+		scanTypes[ext] = true
+	}
+
+	if *compatTable != "" {
+		features, err := slxconvert.LoadFeatures(*compatTable)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		slxconvert.KnownFeatures = append(slxconvert.KnownFeatures, features...)
+	}
+
+	if *manifestPath != "" {
+		if *dryRun || detectMode || *checkOnly || *inZip {
+			fmt.Fprintln(os.Stderr, "Error: --manifest records conversions and cannot be combined with --dry-run, --detect, --check-only or --in-zip")
+			os.Exit(1)
+		}
+		// a rollback needs the originals and a way to tell them apart;
+		// outputs written elsewhere leave the originals where they are
+		inPlace := *outputDir == "" && *outputSuffix == "" && *outputFlag == "" && *outputLongFlag == ""
+		*backup = *backup || inPlace
+		*checksum = true
+	}
+
+	if *execHook != "" && strings.TrimSpace(*execHook) == "" {
+		fmt.Fprintln(os.Stderr, "Error: --exec needs a command")
+		os.Exit(1)
+	}
+
+	if *failFast {
+		*onError = "stop"
+	}
+	switch *onError {
+	case "skip", "stop", "collect":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --on-error %q: expected skip, stop or collect\n", *onError)
+		os.Exit(1)
+	}
+
+	if *emitChanges != "" && !*dryRun {
+		fmt.Fprintln(os.Stderr, "Error: --emit-changes needs --dry-run")
+		os.Exit(1)
+	}
+
+	for _, rel := range strings.Split(*excludeRelease, ",") {
+		if rel = strings.TrimSpace(rel); rel == "" {
+			continue
+		}
+		rel, err := releaseArg("--exclude-release", rel)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		excludedReleases[rel] = true
+	}
+
+	if *downgradeOnly && *upgradeOnly {
+		fmt.Fprintln(os.Stderr, "Error: --downgrade-only and --upgrade-only are mutually exclusive")
+		os.Exit(1)
+	}
+
+	compressionLevel, storeEntries, err = parseCompressionLevel(*compression)
+	if err == nil {
+		maxEntrySize, err = parseSize("max-entry-size", *maxEntryFlag)
+	}
+	if err == nil {
+		maxTotalSize, err = parseSize("max-total-size", *maxTotalFlag)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 
+	if *rollbackPath != "" {
+		if err := rollback(*rollbackPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *selfTest {
+		if err := slxconvert.SelfTest(context.Background(), conversionOptions()); err != nil {
+			fmt.Fprintf(os.Stderr, "Self-test failed:\n%v\n", err)
+			os.Exit(1)
+		}
+		resultf("Self-test passed: UTF-8 flags cleared, forward-slash names, compression methods kept\n")
+		return
+	}
+
+	if *listReleases {
+		for _, rel := range slxconvert.SupportedReleases {
+			fmt.Println(rel)
+		}
+		fmt.Fprintln(os.Stderr, "Metadata entries are rewritten; the others are copied still compressed unless --compression-level asks for recompression.")
+		return
+	}
+
+	if *fromFile != "" {
+		if flag.NArg() > 0 || *outputFlag != "" || *outputLongFlag != "" {
+			fmt.Fprintln(os.Stderr, "Error: --from-file cannot be combined with a path argument or -o/--output")
+			os.Exit(1)
+		}
+		groups, err := readFileList(*fromFile)
+		if err == nil {
+			err = processList(groups)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			finishRun()
+			os.Exit(1)
+		}
+		finishRun()
+		return
+	}
+
 	// Check arguments
 	args := flag.Args()
 	if len(args) < 1 {
@@ -275,34 +2126,85 @@ func main() {
 
 	// Get the path argument
 	path := args[0]
+
+	outPath := *outputFlag
+	if *outputLongFlag != "" {
+		outPath = *outputLongFlag
+	}
+
+	// "-" reads the archive from stdin and/or writes it to stdout
+	if path == "-" || outPath == "-" {
+		if detectMode || *checkOnly || *emitChanges != "" || *normalize || *execHook != "" || *manifestPath != "" {
+			fmt.Fprintln(os.Stderr, "Error: --detect, --print-tree, --check-only, --emit-changes, --normalize, --exec and --manifest do not support stdin/stdout")
+			os.Exit(1)
+		}
+		if path == "-" && outPath == "" {
+			outPath = "-"
+		}
+		if outPath == "-" && (*jsonOutput || *pathsOnly) {
+			fmt.Fprintln(os.Stderr, "Error: --json and --stdout-summary-only cannot be combined with writing the archive to stdout")
+			os.Exit(1)
+		}
+		if err := processStream(path, outPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			finishRun()
+			os.Exit(1)
+		}
+		finishRun()
+		return
+	}
+
 	fileInfo, err := os.Stat(path)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 
-	// Determine if recursive mode is enabled (either flag will work)
-	recursiveMode := *recursiveFlag || *recursiveLongFlag
+	// Determine if recursive mode is enabled (any of the flags will work)
+	recursiveMode := *recursiveFlag || *recursiveLongFlag || *recursiveAliasFlag
 
-	if fileInfo.IsDir() {
-		if recursiveMode {
-			// Process all SLX files in directory recursively
-			if err := processDirectory(path); err != nil {
-				fmt.Fprintln(os.Stderr, "Error:", err)
-				os.Exit(1)
-			}
-		} else {
-			fmt.Fprintf(os.Stderr, "Error: %s is a directory. Use -r or --recursive to process directories.\n", path)
-			flag.Usage()
+	if *inZip && !fileInfo.IsDir() && strings.EqualFold(filepath.Ext(path), ".zip") {
+		if outPath == "" {
+			outPath = outputPathFor(path, *outputSuffix)
+		}
+		if err := processZipBatch(path, outPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			finishRun()
+			os.Exit(1)
+		}
+	} else if fileInfo.IsDir() {
+		if outPath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -o/--output only applies to single files; use --suffix for directories")
+			os.Exit(1)
+		}
+		// without -d only the files directly in the folder are converted,
+		// unless --max-depth asks for more; a release census covers the
+		// whole tree
+		if !recursiveMode && !*reportReleases && *maxDepth < 0 {
+			*maxDepth = 0
+		}
+		if err := processDirectory(path, *outputSuffix); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			finishRun()
 			os.Exit(1)
 		}
 	} else {
 		// Process single file
-		out, err := convertSLX(path)
-		if err != nil {
+		inputRoot = filepath.Dir(path)
+		if outPath == "" {
+			outPath = outputPathFor(path, *outputSuffix)
+		}
+		// the file type is taken from the input's extension, or from the
+		// output's when the input has none
+		if !detectMode && !*checkOnly && !isArchiveExt(path) && !isArchiveExt(outPath) {
+			fmt.Fprintf(os.Stderr, "Error: %s: unrecognized file type; expected one of %s (add types with --ext, or name the output with -o)\n", path, strings.Join(archiveExts, ", "))
+			os.Exit(1)
+		}
+		if err := processFile(path, outPath); err != nil {
 			fmt.Fprintln(os.Stderr, "Error:", err)
+			finishRun()
 			os.Exit(1)
 		}
-		fmt.Println("Created:", out)
 	}
+	finishRun()
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"compress/flate"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		rel      string
+		want     bool
+	}{
+		{[]string{"*.slx"}, "model.slx", true},
+		{[]string{"*.slx"}, "sub/model.slx", true}, // file name
+		{[]string{"*.slx"}, "model.sldd", false},
+		{[]string{"test"}, "test/unit/model.slx", true}, // leading directory
+		{[]string{"test"}, "src/test/model.slx", false},
+		{[]string{"third_party/*"}, "third_party/lib/model.slx", true},
+		{[]string{"sub/model.slx"}, "sub/model.slx", true}, // whole path
+		{[]string{"model_?.slx"}, "model_1.slx", true},
+		{[]string{"[", "*.mdl"}, "model.mdl", true}, // bad patterns never match
+		{nil, "model.slx", false},
+	}
+	for _, tt := range tests {
+		if got := matchesAny(tt.patterns, tt.rel); got != tt.want {
+			t.Errorf("matchesAny(%q, %q) = %v, want %v", tt.patterns, tt.rel, got, tt.want)
+		}
+	}
+}
+
+// writeTree creates an empty file at each of the slash-separated paths
+// below dir.
+func writeTree(t *testing.T, dir string, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// scan runs collectFiles over dir as processDirectory does and returns the
+// slash-separated paths found, relative to dir.
+func scan(t *testing.T, dir string) []string {
+	t.Helper()
+	visited := make(map[string]bool)
+	firstVisit(visited, dir)
+	paths, err := collectFiles(dir, 0, visited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rels []string
+	for _, p := range paths {
+		rel, _ := filepath.Rel(dir, p)
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	return rels
+}
+
+func TestCollectFilesMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.slx", "notes.txt", "sub/b.slx", "sub/deeper/c.sldd")
+	old := *maxDepth
+	t.Cleanup(func() { *maxDepth = old })
+
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{0, []string{"a.slx"}},
+		{1, []string{"a.slx", "sub/b.slx"}},
+		{2, []string{"a.slx", "sub/b.slx", "sub/deeper/c.sldd"}},
+		{-1, []string{"a.slx", "sub/b.slx", "sub/deeper/c.sldd"}},
+	}
+	for _, tt := range tests {
+		*maxDepth = tt.depth
+		if got := scan(t, dir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("--max-depth %d: found %q, want %q", tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestCollectFilesSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.slx", "sub/b.slx")
+	for link, target := range map[string]string{
+		"link.slx":     "a.slx",       // a second route to a file
+		"linked":       "sub",         // and to a directory
+		"sub/loop":     "..",          // a link loop
+		"dangling.slx": "missing.slx", // and a broken link
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+	old := *followSymlinks
+	t.Cleanup(func() { *followSymlinks = old })
+
+	tests := []struct {
+		follow bool
+		want   []string
+	}{
+		{false, []string{"a.slx", "sub/b.slx"}},
+		// each file once, by the first route in name order
+		{true, []string{"a.slx", "linked/b.slx"}},
+	}
+	for _, tt := range tests {
+		*followSymlinks = tt.follow
+		if got := scan(t, dir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("--follow-symlinks=%v: found %q, want %q", tt.follow, got, tt.want)
+		}
+	}
+}
+
+func TestParseCompressionLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		level   int
+		store   bool
+		wantErr bool
+	}{
+		{"", 0, false, false},
+		{"default", 0, false, false},
+		{"DEFAULT", 0, false, false},
+		{"none", 0, true, false},
+		{"store", 0, true, false},
+		{"0", 0, true, false},
+		{"fast", flate.BestSpeed, false, false},
+		{"best", flate.BestCompression, false, false},
+		{"1", 1, false, false},
+		{"9", 9, false, false},
+		{"10", 0, false, true},
+		{"-1", 0, false, true},
+		{"max", 0, false, true},
+	}
+	for _, tt := range tests {
+		level, store, err := parseCompressionLevel(tt.in)
+		if (err != nil) != tt.wantErr || level != tt.level || store != tt.store {
+			t.Errorf("parseCompressionLevel(%q) = %d, %v, %v; want %d, %v, error %v", tt.in, level, store, err, tt.level, tt.store, tt.wantErr)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"-1", -1, false},
+		{"1500", 1500, false},
+		{"512K", 512 << 10, false},
+		{"512k", 512 << 10, false},
+		{"64M", 64 << 20, false},
+		{"2G", 2 << 30, false},
+		{"0", 0, true},
+		{"-2", 0, true},
+		{"M", 0, true},
+		{"1.5G", 0, true},
+		{"2T", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize("max-entry-size", tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "--max-entry-size") {
+			t.Errorf("parseSize(%q) error %q does not name the flag", tt.in, err)
+		}
+	}
+}
+
+func TestReleaseArg(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"R2023b", "R2023b", false},
+		{"r2023b", "R2023b", false},
+		{"R2023B", "R2023b", false},
+		{"2023b", "R2023b", false},
+		{"", "", false},
+		{"R23b", "", true},
+		{"R2023c", "", true},
+		{"latest", "", true},
+	}
+	for _, tt := range tests {
+		got, err := releaseArg("--release", tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("releaseArg(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "--release") {
+			t.Errorf("releaseArg(%q) error %q does not name the flag", tt.in, err)
+		}
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs the POSIX true, false and test commands")
+	}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "model.slx")
+	writeTree(t, dir, "model.slx")
+	old, oldQuiet := *execHook, quiet
+	quiet = true
+	t.Cleanup(func() { *execHook, quiet = old, oldQuiet })
+
+	tests := []struct {
+		hook     string
+		wantExit int
+		wantErr  bool
+	}{
+		{"true", 0, false},
+		{"test -f", 0, false},           // the output is appended
+		{"test -f {}", 0, false},        // or put in place of {}
+		{"test -f {}.missing", 1, true}, // and not appended as well
+		{"false", 1, true},
+		{"slxconvert-no-such-command", -1, true},
+	}
+	for _, tt := range tests {
+		*execHook = tt.hook
+		exit, err := runHook(output)
+		if exit != tt.wantExit || (err != nil) != tt.wantErr {
+			t.Errorf("--exec %q: exit %d, error %v; want %d, error %v", tt.hook, exit, err, tt.wantExit, tt.wantErr)
+		}
+	}
+}
+
+func TestProcessUnitsOnError(t *testing.T) {
+	dir := t.TempDir()
+	writeModel(t, filepath.Join(dir, "a.slx"), "R2024b")
+	if err := os.WriteFile(filepath.Join(dir, "bad.slx"), []byte(strings.Repeat("not a zip ", 10)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeModel(t, filepath.Join(dir, "c.slx"), "R2024b")
+	units := singles([]string{filepath.Join(dir, "a.slx"), filepath.Join(dir, "bad.slx"), filepath.Join(dir, "c.slx")})
+
+	oldMode, oldJobs, oldRelease, oldQuiet := *onError, *jobs, selectedRelease, quiet
+	*jobs, selectedRelease, quiet = 1, "R2023b", true
+	t.Cleanup(func() {
+		*onError, *jobs, selectedRelease, quiet = oldMode, oldJobs, oldRelease, oldQuiet
+		results = nil
+	})
+
+	tests := []struct {
+		mode    string
+		wantErr bool
+		// the number of files converted, or -1 if it depends on timing
+		converted int
+	}{
+		{"skip", false, 2},
+		{"collect", true, 2},
+		{"stop", true, -1},
+	}
+	for _, tt := range tests {
+		*onError = tt.mode
+		results = nil
+		suffix := "_" + tt.mode
+		err := processUnits(units, suffix)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("--on-error %s: error %v, want error %v", tt.mode, err, tt.wantErr)
+		}
+		converted := 0
+		for _, r := range results {
+			if r.Error == "" && r.Output != "" {
+				converted++
+			}
+		}
+		if tt.converted >= 0 && converted != tt.converted {
+			t.Errorf("--on-error %s: converted %d files, want %d", tt.mode, converted, tt.converted)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "a"+suffix+".slx")); err != nil {
+			t.Errorf("--on-error %s: file before the failure not converted: %v", tt.mode, err)
+		}
+	}
+}
+
+func TestNormalizeSkipsMDL(t *testing.T) {
+	dir := t.TempDir()
+	mdl := filepath.Join(dir, "model.mdl")
+	if err := os.WriteFile(mdl, []byte("Model {\n  Name \"demo\"\n  Version 23.2\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldNormalize, oldQuiet := *normalize, quiet
+	*normalize, quiet = true, true
+	t.Cleanup(func() {
+		*normalize, quiet = oldNormalize, oldQuiet
+		results = nil
+	})
+	results = nil
+
+	if err := processFile(mdl, mdl); err != nil {
+		t.Fatalf("normalize of a .mdl failed: %v", err)
+	}
+	if len(results) != 1 || !results[0].Skipped || results[0].SkipReason == "" {
+		t.Errorf("got results %+v, want one skipped file with a reason", results)
+	}
+}
+
+func TestCheckOnlyMixedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeModel(t, filepath.Join(dir, "a.slx"), "R2024b")
+	if err := os.WriteFile(filepath.Join(dir, "b.mdl"), []byte("Model {\n  Name \"demo\"\n  Version 24.2\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldCheck, oldRelease, oldQuiet := *checkOnly, selectedRelease, quiet
+	*checkOnly, selectedRelease, quiet = true, "R2022a", true
+	t.Cleanup(func() {
+		*checkOnly, selectedRelease, quiet = oldCheck, oldRelease, oldQuiet
+		results = nil
+	})
+	results = nil
+
+	if err := processDirectory(dir, ""); err != nil {
+		t.Fatalf("--check-only of a directory with a .mdl failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			t.Errorf("%s: %s", r.Input, r.Error)
+		}
+		if filepath.Ext(r.Input) == ".mdl" && len(r.Incompatibilities) != 1 {
+			t.Errorf("%s: got %+v, want the Variant Assembly Subsystem", r.Input, r.Incompatibilities)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"convertSLX/slxconvert"
+)
+
+// manifest is the --manifest record of a run: every file it converted,
+// with what is needed to put the original back with --rollback.
+type manifest struct {
+	Created time.Time       `json:"created"`
+	Files   []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	Input           string `json:"input"`
+	Output          string `json:"output"`
+	OriginalRelease string `json:"originalRelease,omitempty"`
+	TargetRelease   string `json:"targetRelease,omitempty"`
+	Backup          string `json:"backup"`
+	InputSHA256     string `json:"inputSha256"`
+	OutputSHA256    string `json:"outputSha256"`
+}
+
+// writeManifest saves the files converted so far to --manifest. Failed and
+// skipped files are left out, since there is nothing to roll back. Paths
+// are made absolute so that --rollback works from any directory.
+func writeManifest() {
+	if *manifestPath == "" {
+		return
+	}
+	m := manifest{Created: time.Now().UTC().Truncate(time.Second), Files: []manifestEntry{}}
+	resultsMu.Lock()
+	for _, r := range results {
+		if r.Error != "" || r.Skipped || r.Output == "" {
+			continue
+		}
+		m.Files = append(m.Files, manifestEntry{
+			Input:           absPath(r.Input),
+			Output:          absPath(r.Output),
+			OriginalRelease: r.OriginalRelease,
+			TargetRelease:   r.TargetRelease,
+			Backup:          absPath(r.Backup),
+			InputSHA256:     r.InputSHA256,
+			OutputSHA256:    r.OutputSHA256,
+		})
+	}
+	resultsMu.Unlock()
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Input < m.Files[j].Input })
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err == nil {
+		err = slxconvert.WriteAtomic(*manifestPath, func(w io.Writer) error {
+			_, err := w.Write(append(data, '\n'))
+			return err
+		})
+	}
+	if err != nil {
+		eprintf("Error: manifest: %v\n", err)
+		return
+	}
+	printf("Manifest: %s (%d file(s))\n", *manifestPath, len(m.Files))
+}
+
+// rollback restores the originals of the files listed in the manifest at
+// path from their backups, removing each backup once the original is back.
+// A file that changed since the conversion is left alone unless --force is
+// set, and a backup that no longer matches the original's checksum is
+// never used. Outputs written beside their input are removed instead, the
+// input being the original.
+func rollback(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	failed := 0
+	for _, e := range m.Files {
+		if err := rollbackFile(e); err != nil {
+			eprintf("Error: %s: %v\n", e.Input, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) not rolled back", failed, len(m.Files))
+	}
+	if *dryRun {
+		resultf("Would roll back %d file(s)\n", len(m.Files))
+	} else {
+		resultf("Rolled back %d file(s)\n", len(m.Files))
+	}
+	return nil
+}
+
+// rollbackFile undoes the conversion recorded in e.
+func rollbackFile(e manifestEntry) error {
+	inPlace := filepath.Clean(e.Output) == filepath.Clean(e.Input)
+	sum, err := slxconvert.HashFile(e.Output)
+	switch {
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return err
+	case inPlace && sum == e.InputSHA256:
+		printf("Already restored: %s\n", e.Input)
+		return nil
+	case err == nil && sum != e.OutputSHA256 && !*force:
+		return fmt.Errorf("%s changed since it was converted (use --force to roll back anyway)", filepath.Base(e.Output))
+	}
+
+	if !inPlace {
+		if *dryRun {
+			printf("Would remove: %s\n", e.Output)
+			return nil
+		}
+		if err := os.Remove(e.Output); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		printf("Removed: %s\n", e.Output)
+		return nil
+	}
+
+	if e.Backup == "" {
+		return errors.New("no backup recorded")
+	}
+	if sum, err := slxconvert.HashFile(e.Backup); err != nil {
+		return err
+	} else if sum != e.InputSHA256 {
+		return fmt.Errorf("backup %s does not match the original's checksum", e.Backup)
+	}
+	if *dryRun {
+		printf("Would restore: %s from %s\n", e.Input, e.Backup)
+		return nil
+	}
+	// the converted file kept the original's timestamp (--preserve-mtime),
+	// which the backup, being a fresh copy, does not have
+	info, statErr := os.Stat(e.Input)
+	src, err := os.Open(e.Backup)
+	if err != nil {
+		return err
+	}
+	err = slxconvert.WriteAtomic(e.Input, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+	src.Close()
+	if err != nil {
+		return err
+	}
+	if statErr == nil {
+		os.Chtimes(e.Input, time.Time{}, info.ModTime())
+	}
+	// the backup has served its purpose, and left in place it would stop
+	// the next --backup run of the same file
+	if sum, err := slxconvert.HashFile(e.Input); err != nil {
+		return err
+	} else if sum != e.InputSHA256 {
+		return fmt.Errorf("restored %s does not match the original's checksum; keeping %s", filepath.Base(e.Input), e.Backup)
+	}
+	if err := os.Remove(e.Backup); err != nil {
+		return err
+	}
+	printf("Restored: %s from %s\n", e.Input, e.Backup)
+	return nil
+}
+
+// absPath returns path made absolute, or as it is if that fails or path is
+// empty.
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
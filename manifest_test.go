@@ -0,0 +1,164 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"convertSLX/slxconvert"
+)
+
+// writeModel creates a minimal archive at path whose metadata reads
+// release.
+func writeModel(t *testing.T, path, release string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range map[string]string{
+		"metadata/coreProperties.xml":   `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties"><cp:version>` + release + `</cp:version></cp:coreProperties>`,
+		"metadata/mwcoreProperties.xml": `<mwcoreProperties><release>` + release + `</release></mwcoreProperties>`,
+		"simulink/blockdiagram.xml":     `<ModelInformation><Model Name="demo"/></ModelInformation>`,
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupManifest points --manifest at a file in a fresh directory and
+// clears the run's results, undoing both when t ends.
+func setupManifest(t *testing.T) (dir, manifest string) {
+	t.Helper()
+	dir = t.TempDir()
+	oldPath, oldQuiet, oldForce := *manifestPath, quiet, *force
+	*manifestPath = filepath.Join(dir, "manifest.json")
+	quiet = true
+	t.Cleanup(func() {
+		*manifestPath, quiet, *force = oldPath, oldQuiet, oldForce
+		results = nil
+	})
+	results = nil
+	return dir, *manifestPath
+}
+
+// convertRecorded converts path in place with a backup, as a --manifest
+// run does, records the result and writes the manifest.
+func convertRecorded(t *testing.T, path string) {
+	t.Helper()
+	res, err := slxconvert.Convert(path, path, "R2023b", slxconvert.Options{Backup: true, Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = nil
+	recordResult(fileResult{
+		Input:           path,
+		Output:          path,
+		OriginalRelease: res.OriginalRelease,
+		TargetRelease:   "R2023b",
+		Backup:          res.Backup,
+		InputSHA256:     res.InputSHA256,
+		OutputSHA256:    res.OutputSHA256,
+	})
+	writeManifest()
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	dir, manifest := setupManifest(t)
+	writeModel(t, filepath.Join(dir, "model.slx"), "R2024b")
+	orig, _ := os.ReadFile(filepath.Join(dir, "model.slx"))
+
+	// convert by a relative path, roll back from somewhere else
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	convertRecorded(t, "model.slx")
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := rollback(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := os.ReadFile(filepath.Join(dir, "model.slx")); !bytes.Equal(got, orig) {
+		t.Error("model.slx not restored")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "model.slx.bak")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("backup left behind: %v", err)
+	}
+	// a second rollback finds nothing to do
+	if err := rollback(manifest); err != nil {
+		t.Errorf("second rollback: %v", err)
+	}
+
+	// and the file can be converted with a backup again
+	convertRecorded(t, filepath.Join(dir, "model.slx"))
+}
+
+func TestRollbackChecksumMismatch(t *testing.T) {
+	dir, manifest := setupManifest(t)
+	model := filepath.Join(dir, "model.slx")
+	writeModel(t, model, "R2024b")
+	orig, _ := os.ReadFile(model)
+	convertRecorded(t, model)
+
+	// edited since the conversion: left alone unless forced
+	writeModel(t, model, "R2025a")
+	edited, _ := os.ReadFile(model)
+	if err := rollback(manifest); err == nil {
+		t.Fatal("rollback of a changed file succeeded")
+	}
+	if got, _ := os.ReadFile(model); !bytes.Equal(got, edited) {
+		t.Error("changed file was overwritten without --force")
+	}
+
+	// a backup that is not the original is never used, even forced
+	*force = true
+	bak := model + ".bak"
+	os.WriteFile(bak, []byte("not the original"), 0o644)
+	if err := rollback(manifest); err == nil {
+		t.Fatal("rollback from a mismatched backup succeeded")
+	}
+	if got, _ := os.ReadFile(model); !bytes.Equal(got, edited) {
+		t.Error("file restored from a mismatched backup")
+	}
+
+	os.WriteFile(bak, orig, 0o644)
+	if err := rollback(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(model); !bytes.Equal(got, orig) {
+		t.Error("--force rollback did not restore the original")
+	}
+}
+
+func TestRollbackMissingBackup(t *testing.T) {
+	dir, manifest := setupManifest(t)
+	model := filepath.Join(dir, "model.slx")
+	writeModel(t, model, "R2024b")
+	convertRecorded(t, model)
+	converted, _ := os.ReadFile(model)
+
+	os.Remove(model + ".bak")
+	err := rollback(manifest)
+	if err == nil || !strings.Contains(err.Error(), "not rolled back") {
+		t.Fatalf("rollback error = %v, want a failed file", err)
+	}
+	if got, _ := os.ReadFile(model); !bytes.Equal(got, converted) {
+		t.Error("converted file changed without a backup to restore from")
+	}
+}
@@ -0,0 +1,468 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Entry records how an entry was stored in the original archive so that
+// ZipDir can reproduce it.
+type Entry struct {
+	Name   string
+	Method uint16
+	Mode   os.FileMode
+	// Extra holds the entry's extra fields, less the ones the zip writer
+	// generates itself (see preservedExtra), and Comment its comment. Both
+	// are left empty when Options.StripExtra is set.
+	Extra   []byte
+	Comment string
+}
+
+// Extra field IDs that archive/zip writes on its own and that must not be
+// copied from the original entry.
+const (
+	zip64ExtraID   = 0x0001 // sizes and offsets for large archives
+	extTimeExtraID = 0x5455 // extended timestamp, derived from Modified
+)
+
+// preservedExtra returns the extra fields of extra worth carrying over to a
+// rewritten entry: all of them except those archive/zip regenerates, which
+// would otherwise appear twice. A truncated trailing field is dropped.
+func preservedExtra(extra []byte) []byte {
+	var out []byte
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		if id != zip64ExtraID && id != extTimeExtraID {
+			out = append(out, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+	return out
+}
+
+// copyExtras carries the extra fields and comment of an original entry
+// over to header, unless opts.StripExtra is set.
+func copyExtras(header *zip.FileHeader, extra []byte, comment string, opts Options) {
+	if opts.StripExtra {
+		return
+	}
+	header.Extra = preservedExtra(extra)
+	header.Comment = comment
+}
+
+// extractPerm returns the permissions to create an extracted entry with.
+// They follow the archive, but the owner always keeps the read/write (and
+// for folders search) access the conversion itself needs; entries with no
+// recorded permissions get conventional defaults.
+func extractPerm(mode os.FileMode, dir bool) os.FileMode {
+	perm := mode.Perm()
+	switch {
+	case perm == 0 && dir:
+		return 0o755
+	case perm == 0:
+		return 0o644
+	case dir:
+		return perm | 0o700
+	}
+	return perm | 0o600
+}
+
+// Unzip extracts every entry of the archive src below dest and returns a
+// manifest of the files it extracted. Backslashes in entry names separate
+// folders, as they do when Windows extracts them. It stops with ctx.Err()
+// once ctx is done.
+func Unzip(ctx context.Context, src, dest string, opts Options) ([]Entry, error) {
+	r, err := openArchive(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if err := checkSizes(&r.Reader, opts); err != nil {
+		return nil, err
+	}
+
+	var manifest []Entry
+	budget := newSizeBudget(opts)
+
+	root := filepath.Clean(dest) + string(os.PathSeparator)
+	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fpath := filepath.Join(dest, filepath.FromSlash(slashName(f.Name)))
+		// reject entries such as "../../x" that would escape dest (Zip Slip)
+		if !strings.HasPrefix(filepath.Clean(fpath)+string(os.PathSeparator), root) {
+			return nil, fmt.Errorf("%w: illegal entry path %q", ErrInvalidArchive, f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(fpath, extractPerm(f.Mode(), true))
+			continue
+		}
+		opts.logf("extract %s", f.Name)
+		e := Entry{Name: f.Name, Method: f.Method, Mode: f.Mode()}
+		if !opts.StripExtra {
+			e.Extra, e.Comment = preservedExtra(f.Extra), f.Comment
+		}
+		manifest = append(manifest, e)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0o755); err != nil {
+			return nil, err
+		}
+		if err := extractFile(ctx, f, fpath, budget); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// extractFile writes the contents of the entry f to fpath, closing both
+// before it returns so that a large archive does not hold a descriptor
+// open for every entry until the extraction ends.
+func extractFile(ctx context.Context, f *zip.File, fpath string, budget *sizeBudget) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, extractPerm(f.Mode(), false))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, ctxReader{ctx, budget.reader(f.Name, rc)}); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// openArchive opens the zip archive at path. A file that exists but is not
+// a readable zip is reported as ErrInvalidArchive; file system errors are
+// returned as they are.
+func openArchive(path string) (*zip.ReadCloser, error) {
+	r, err := zip.OpenReader(path)
+	var pathErr *fs.PathError
+	if err != nil && !errors.As(err, &pathErr) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	return r, err
+}
+
+// ctxReader fails reads once ctx is done, so that copying a large entry
+// can be cancelled part way through.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// utf8Flag is general purpose bit 11, which marks an entry name as UTF-8.
+// MATLAB fails to open archives whose entries carry it.
+const utf8Flag = 1 << 11
+
+// MATLABZipWriter wraps a zip.Writer so that every entry it writes follows
+// the rules MATLAB relies on: names use forward slashes, entries are either
+// stored or deflated, and the UTF-8 flag is never set. All archive writing
+// for a conversion goes through it, so the rules are kept in one place.
+type MATLABZipWriter struct {
+	zw *zip.Writer
+}
+
+// NewMATLABZipWriter returns a MATLABZipWriter writing to w and deflating
+// at the given flate level; 0 selects flate.DefaultCompression.
+func NewMATLABZipWriter(w io.Writer, level int) *MATLABZipWriter {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return newFlateWriter(out, level)
+	})
+	return &MATLABZipWriter{zw: zw}
+}
+
+// flateWriters pools flate writers by compression level. Each carries
+// several hundred KB of state, which a directory run would otherwise
+// allocate afresh for every entry of every model.
+var flateWriters sync.Map // int -> *sync.Pool
+
+// pooledFlateWriter returns its flate.Writer to pool once closed.
+type pooledFlateWriter struct {
+	*flate.Writer
+	pool *sync.Pool
+}
+
+func (w *pooledFlateWriter) Close() error {
+	err := w.Writer.Close()
+	w.pool.Put(w.Writer)
+	return err
+}
+
+// newFlateWriter returns a flate writer at level writing to out, reusing
+// one from flateWriters when possible.
+func newFlateWriter(out io.Writer, level int) (io.WriteCloser, error) {
+	p, _ := flateWriters.LoadOrStore(level, new(sync.Pool))
+	pool := p.(*sync.Pool)
+	if fw, ok := pool.Get().(*flate.Writer); ok {
+		fw.Reset(out)
+		return &pooledFlateWriter{fw, pool}, nil
+	}
+	fw, err := flate.NewWriter(out, level)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledFlateWriter{fw, pool}, nil
+}
+
+// CreateHeader makes h MATLAB-compatible, see matlabHeader, and adds an
+// entry for it. The entry's contents are written to the returned
+// io.Writer until the next call to CreateHeader or Close.
+func (m *MATLABZipWriter) CreateHeader(h *zip.FileHeader) (io.Writer, error) {
+	matlabHeader(h)
+	return m.zw.CreateHeader(h)
+}
+
+// CreateRaw makes h MATLAB-compatible like CreateHeader and adds an entry
+// whose contents, already compressed with h.Method, are written to the
+// returned io.Writer as they are. h must carry the entry's CRC-32 and
+// sizes. Only stored and deflated entries can be copied this way, since
+// matlabHeader would otherwise relabel data it has not recompressed.
+func (m *MATLABZipWriter) CreateRaw(h *zip.FileHeader) (io.Writer, error) {
+	if h.Method != zip.Store && h.Method != zip.Deflate {
+		return nil, fmt.Errorf("%s: cannot copy an entry compressed with method %d", h.Name, h.Method)
+	}
+	matlabHeader(h)
+	return m.zw.CreateRaw(h)
+}
+
+// SetComment sets the end-of-archive comment.
+func (m *MATLABZipWriter) SetComment(comment string) error {
+	return m.zw.SetComment(comment)
+}
+
+// Close finishes writing the archive. It does not close the underlying
+// writer.
+func (m *MATLABZipWriter) Close() error {
+	return m.zw.Close()
+}
+
+// slashName returns the entry name with backslash separators turned into
+// forward slashes, the name it is extracted under and written back as.
+func slashName(name string) string {
+	return strings.ReplaceAll(name, "\\", "/")
+}
+
+// matlabHeader rewrites h in place to follow the rules MATLAB relies on.
+// Backslashes in the name become forward slashes, any method other than
+// Store becomes Deflate, and the UTF-8 flag is cleared. NonUTF8 is set too,
+// since archive/zip would otherwise set the flag again for a non-ASCII name.
+func matlabHeader(h *zip.FileHeader) {
+	h.Name = slashName(h.Name)
+	if h.Method != zip.Store {
+		h.Method = zip.Deflate
+	}
+	h.Flags &^= utf8Flag
+	h.NonUTF8 = true
+}
+
+// newZipWriter returns a MATLABZipWriter deflating at the level selected
+// in opts and carrying opts.archiveComment.
+func newZipWriter(w io.Writer, opts Options) *MATLABZipWriter {
+	if opts.outputHash != nil {
+		w = io.MultiWriter(w, opts.outputHash)
+	}
+	zw := NewMATLABZipWriter(w, opts.CompressionLevel)
+	// Keep the original archive comment, if any
+	zw.SetComment(opts.archiveComment)
+	return zw
+}
+
+// newEntryHeader builds a MATLAB-compatible header for an archive entry.
+// Entries originally stored uncompressed stay that way; everything else is
+// deflated. A non-zero mode is recorded in the header's external attributes.
+func newEntryHeader(name string, modified time.Time, method uint16, mode os.FileMode) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: modified,
+	}
+	if mode != 0 {
+		header.SetMode(mode)
+	}
+	matlabHeader(header)
+	return header
+}
+
+// rawHeader returns the header for copying f with CreateRaw. It keeps the
+// original's sizes, checksum, timestamp and attributes, and carries over
+// its extra fields and comment as copyExtras does. Since CreateRaw adds no
+// extended timestamp of its own, one is built from f.Modified, as
+// CreateHeader would.
+func rawHeader(f *zip.File, opts Options) *zip.FileHeader {
+	h := f.FileHeader
+	h.Extra, h.Comment = nil, ""
+	copyExtras(&h, f.Extra, f.Comment, opts)
+	if !f.Modified.IsZero() {
+		var ts [9]byte
+		binary.LittleEndian.PutUint16(ts[0:], extTimeExtraID)
+		binary.LittleEndian.PutUint16(ts[2:], 5)
+		ts[4] = 1 // modification time only
+		binary.LittleEndian.PutUint32(ts[5:], uint32(f.Modified.Unix()))
+		h.Extra = append(h.Extra, ts[:]...)
+	}
+	return &h
+}
+
+// copiesRaw reports whether f can be copied into the new archive without
+// decompressing it: it is stored or deflated, not encrypted, and opts
+// asks for no recompression (see Options.recompresses).
+func copiesRaw(f *zip.File, opts Options) bool {
+	const encrypted = 0x1
+	return !opts.recompresses() && (f.Method == zip.Store || f.Method == zip.Deflate) && f.Flags&encrypted == 0
+}
+
+// ZipDir packs the tree below src into a MATLAB-compatible archive at dest.
+// Files listed in manifest are written first, in manifest order and with
+// their original compression method and mode; any other files found under
+// src follow in walk order and are deflated with their on-disk mode. It
+// stops with ctx.Err() once ctx is done. dest is written with WriteAtomic,
+// so it is only replaced once the archive is complete.
+func ZipDir(ctx context.Context, src, dest string, manifest []Entry, opts Options) error {
+	return WriteAtomic(dest, func(w io.Writer) error {
+		return zipDir(ctx, src, w, manifest, opts)
+	})
+}
+
+// zipDir is ZipDir writing the archive to w.
+func zipDir(ctx context.Context, src string, w io.Writer, manifest []Entry, opts Options) error {
+	// Create a new zip writer
+	zw := newZipWriter(w, opts)
+
+	added := make(map[string]bool, len(manifest))
+	addFile := func(e Entry, path string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		added[slashName(e.Name)] = true
+		opts.logf("write %s", e.Name)
+		header := newEntryHeader(e.Name, info.ModTime(), opts.entryMethod(e.Method), e.Mode)
+		copyExtras(header, e.Extra, e.Comment, opts)
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(w, ctxReader{ctx, f})
+		return err
+	}
+
+	for _, e := range manifest {
+		path := filepath.Join(src, filepath.FromSlash(slashName(e.Name)))
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := addFile(e, path, info); err != nil {
+			return err
+		}
+	}
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		rel = slashName(filepath.ToSlash(rel))
+		if added[rel] {
+			return nil
+		}
+		return addFile(Entry{Name: rel, Method: zip.Deflate, Mode: info.Mode()}, path, info)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// nonASCIINames returns the names of the entries of r that contain
+// characters outside ASCII. MATLAB reads entry names without the UTF-8 flag
+// in a legacy code page, so such names may not survive a conversion intact.
+func nonASCIINames(r *zip.Reader, opts Options) []string {
+	var names []string
+	for _, f := range r.File {
+		if !isASCII(f.Name) {
+			opts.logf("non-ASCII entry name %q", f.Name)
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// checkArchive confirms that r looks like an SLX/SLDD archive and that at
+// least one of tags is present in its metadata entries files, returning
+// the tags found.
+// An archive whose release lives somewhere unexpected would otherwise be
+// rezipped unchanged and look like a successful conversion.
+func checkArchive(r *zip.Reader, files, tags []string) (map[string]string, error) {
+	hasMetadata := false
+	for _, f := range r.File {
+		if strings.HasPrefix(f.Name, "metadata/") || strings.Contains(f.Name, "/metadata/") {
+			hasMetadata = true
+			break
+		}
+	}
+	if !hasMetadata {
+		return nil, fmt.Errorf("%w: no metadata/ folder", ErrInvalidArchive)
+	}
+
+	found, err := detectReader(r, files, tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w (%s) in %s", ErrNoMetadata, strings.Join(tags, ", "), strings.Join(files, ", "))
+	}
+	return found, nil
+}
@@ -0,0 +1,150 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnzipZipDirRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "model.slx")
+	writeFixture(t, src, modelFixture)
+
+	work := filepath.Join(dir, "work")
+	manifest, err := Unzip(context.Background(), src, work, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != len(modelFixture) {
+		t.Fatalf("manifest has %d entries, want %d", len(manifest), len(modelFixture))
+	}
+	// a backslash separates folders, as it does on Windows
+	if _, err := os.Stat(filepath.Join(work, "simulink", "windows.xml")); err != nil {
+		t.Errorf("backslash entry not extracted below simulink: %v", err)
+	}
+
+	// an extra file under the work dir must be picked up after the manifest
+	if err := os.WriteFile(filepath.Join(work, "extra.txt"), []byte("extra"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out.slx")
+	if err := ZipDir(context.Background(), work, out, manifest, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	headers, contents := readArchive(t, out)
+	checkMATLABCompatible(t, headers)
+
+	if len(headers) != len(modelFixture)+1 {
+		t.Fatalf("got %d entries, want %d", len(headers), len(modelFixture)+1)
+	}
+	for i, e := range modelFixture {
+		name := strings.ReplaceAll(e.Name, `\`, "/")
+		h := headers[i]
+		if h.Name != name {
+			t.Errorf("entry %d is %s, want %s (manifest order)", i, h.Name, name)
+		}
+		if h.Method != e.Method {
+			t.Errorf("%s: method %d, want %d", name, h.Method, e.Method)
+		}
+		if contents[name] != e.Body {
+			t.Errorf("%s: content changed by round trip", name)
+		}
+	}
+	if last := headers[len(headers)-1]; last.Name != "extra.txt" || last.Method != zip.Deflate {
+		t.Errorf("last entry is %s (method %d), want deflated extra.txt", last.Name, last.Method)
+	}
+}
+
+func TestUnzipRejectsZipSlip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "evil.slx")
+	writeFixture(t, src, []fixtureEntry{
+		{Name: "metadata/coreProperties.xml", Body: "<cp><version>R2024b</version></cp>"},
+		{Name: "../../escaped.txt", Body: "gotcha"},
+	})
+
+	_, err := Unzip(context.Background(), src, filepath.Join(dir, "work"), Options{})
+	if err == nil || !strings.Contains(err.Error(), "illegal entry path") {
+		t.Fatalf("Unzip error = %v, want illegal entry path", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "..", "escaped.txt")); err == nil {
+		t.Error("entry was written outside the destination")
+	}
+}
+
+func TestUnzipHonoursCancellation(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "model.slx")
+	writeFixture(t, src, modelFixture)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Unzip(ctx, src, filepath.Join(dir, "work"), Options{}); err != context.Canceled {
+		t.Fatalf("Unzip error = %v, want context.Canceled", err)
+	}
+}
+
+func TestNewEntryHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     uint16
+		wantName   string
+		wantMethod uint16
+	}{
+		{"metadata/coreProperties.xml", zip.Deflate, "metadata/coreProperties.xml", zip.Deflate},
+		{`simulink\blockdiagram.xml`, zip.Deflate, "simulink/blockdiagram.xml", zip.Deflate},
+		{"simulink/thumb.png", zip.Store, "simulink/thumb.png", zip.Store},
+		{"odd/method.bin", 14, "odd/method.bin", zip.Deflate},
+	}
+	for _, tt := range tests {
+		h := newEntryHeader(tt.name, fixtureTime, tt.method, 0)
+		if h.Name != tt.wantName || h.Method != tt.wantMethod {
+			t.Errorf("newEntryHeader(%q, %d) = %q, %d; want %q, %d", tt.name, tt.method, h.Name, h.Method, tt.wantName, tt.wantMethod)
+		}
+		if h.Flags&utf8Flag != 0 {
+			t.Errorf("newEntryHeader(%q): UTF-8 flag set", tt.name)
+		}
+	}
+}
+
+func TestMATLABZipWriter(t *testing.T) {
+	var buf bytes.Buffer
+	zw := NewMATLABZipWriter(&buf, 0)
+	for _, h := range []*zip.FileHeader{
+		{Name: `simulink\blockdiagram.xml`, Method: zip.Deflate, Flags: 1 << 11},
+		{Name: "resources/Küche.png", Method: zip.Store},
+		{Name: "odd/method.bin", Method: 14},
+	} {
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "data")
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var headers []zip.FileHeader
+	for _, f := range r.File {
+		headers = append(headers, f.FileHeader)
+	}
+	checkMATLABCompatible(t, headers)
+	if got := headers[0].Name; got != "simulink/blockdiagram.xml" {
+		t.Errorf("name = %q, want forward slashes", got)
+	}
+	if got := headers[1].Method; got != zip.Store {
+		t.Errorf("stored entry written with method %d", got)
+	}
+}
@@ -0,0 +1,43 @@
+package slxconvert
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteAtomic creates path by calling write with a temporary file in the
+// same directory, then flushing it to disk and renaming it over path. A
+// failed, cancelled or interrupted write therefore leaves any existing
+// path untouched. path keeps its permissions if it exists; a new file gets
+// 0644.
+func WriteAtomic(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	// tracked like a work directory so that an interrupt removes it
+	trackWorkDir(f.Name())
+	defer removeWorkDir(f.Name())
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,56 @@
+package slxconvert
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "model.slx")
+
+	if err := WriteAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o644 {
+		t.Fatalf("new file: %v, %v; want mode 0644", info, err)
+	}
+
+	// a failed write must leave the existing file and its mode alone
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	boom := errors.New("boom")
+	err := WriteAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("got %v, want %v", err, boom)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "new" {
+		t.Errorf("failed write changed the file to %q", b)
+	}
+
+	if err := WriteAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "replaced")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	if b, _ := os.ReadFile(path); string(b) != "replaced" || info.Mode().Perm() != 0o600 {
+		t.Errorf("got %q with mode %v, want \"replaced\" with mode 0600", b, info.Mode().Perm())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
@@ -0,0 +1,95 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ExtractBatch extracts src, a plain .zip holding a set of models, to a
+// new temporary directory under opts.WorkDir so that it can be converted like any other
+// folder. It returns the directory, a manifest of its files for PackBatch
+// and a function that removes the directory again.
+func ExtractBatch(ctx context.Context, src string, opts Options) (string, []Entry, func(), error) {
+	dir, err := os.MkdirTemp(opts.WorkDir, "slxconvert_batch")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	trackWorkDir(dir)
+	cleanup := func() { removeWorkDir(dir) }
+
+	manifest, err := Unzip(ctx, src, dir, opts)
+	if err != nil {
+		cleanup()
+		return "", nil, nil, err
+	}
+	return dir, manifest, cleanup, nil
+}
+
+// PackBatch writes the tree below dir back out as a plain .zip at dest,
+// the counterpart of ExtractBatch. Unlike ZipDir it makes an ordinary
+// archive rather than a MATLAB one: names keep their UTF-8 flag, entries
+// in manifest come first with their original method, and files that were
+// added in dir since extraction follow in walk order.
+func PackBatch(ctx context.Context, dir, dest string, manifest []Entry) error {
+	return WriteAtomic(dest, func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		added := make(map[string]bool, len(manifest))
+		addFile := func(rel, path string, info os.FileInfo, method uint16) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			added[slashName(rel)] = true
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = rel
+			header.Method = method
+			fw, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(fw, ctxReader{ctx, f})
+			return err
+		}
+
+		for _, e := range manifest {
+			path := filepath.Join(dir, filepath.FromSlash(slashName(e.Name)))
+			info, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := addFile(e.Name, path, info, e.Method); err != nil {
+				return err
+			}
+		}
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			if rel = slashName(filepath.ToSlash(rel)); added[rel] {
+				return nil
+			}
+			return addFile(rel, path, info, zip.Deflate)
+		})
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
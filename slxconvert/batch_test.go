@@ -0,0 +1,67 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "model.slx")
+	writeFixture(t, model, modelFixture)
+	body, err := os.ReadFile(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := filepath.Join(dir, "batch.zip")
+	writeFixture(t, batch, []fixtureEntry{
+		{Name: "models/a.slx", Body: string(body), Method: zip.Store},
+		{Name: "readme.txt", Body: "models", Method: zip.Deflate},
+		{Name: `docs\notes.txt`, Body: "notes", Method: zip.Deflate},
+	})
+
+	ctx := context.Background()
+	work, manifest, cleanup, err := ExtractBatch(ctx, batch, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if _, err := Convert(filepath.Join(work, "models", "a.slx"), filepath.Join(work, "models", "a.slx"), "R2023b", Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out.zip")
+	if err := PackBatch(ctx, work, out, manifest); err != nil {
+		t.Fatal(err)
+	}
+	headers, contents := readArchive(t, out)
+	if len(headers) != 3 || headers[0].Name != "models/a.slx" || headers[1].Name != "readme.txt" || headers[2].Name != `docs\notes.txt` {
+		t.Fatalf("got entries %v, want models/a.slx, readme.txt and docs\\notes.txt once each, in order", headers)
+	}
+	if headers[0].Method != zip.Store {
+		t.Errorf("models/a.slx: method %d, want stored", headers[0].Method)
+	}
+	if contents["readme.txt"] != "models" {
+		t.Errorf("readme.txt: got %q", contents["readme.txt"])
+	}
+
+	inner := filepath.Join(dir, "inner.slx")
+	if err := os.WriteFile(inner, []byte(contents["models/a.slx"]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	found, err := Detect(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := PrimaryRelease(found); got != "R2023b" {
+		t.Errorf("repackaged model at %s, want R2023b", got)
+	}
+
+	cleanup()
+	if _, err := os.Stat(work); !os.IsNotExist(err) {
+		t.Errorf("batch directory not removed: %v", err)
+	}
+}
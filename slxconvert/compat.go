@@ -0,0 +1,181 @@
+package slxconvert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// Feature marks a block or capability that first appeared in a release.
+// A model downgraded below Introduced may still open in the older MATLAB
+// but silently lose whatever relied on the feature.
+// BlockType, if set, is the BlockType attribute of the model's <Block>
+// elements that use the feature, and Parameter the name of a block
+// parameter (a <P Name="..."> child) that only such blocks carry; either
+// or both let CheckCompatibility find them.
+type Feature struct {
+	Name       string `json:"name"`
+	Introduced string `json:"introduced"`
+	BlockType  string `json:"blockType,omitempty"`
+	Parameter  string `json:"parameter,omitempty"`
+}
+
+// detectable reports whether f says how to find it in a model.
+func (f Feature) detectable() bool {
+	return f.BlockType != "" || f.Parameter != ""
+}
+
+// count returns how many of the blocks in doc use f.
+func (f Feature) count(doc *etree.Document) int {
+	path := "//Block"
+	if f.BlockType != "" {
+		path += "[@BlockType='" + f.BlockType + "']"
+	}
+	n := 0
+	for _, b := range doc.FindElements(path) {
+		if f.Parameter == "" || b.FindElement("P[@Name='"+f.Parameter+"']") != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// KnownFeatures is a deliberately conservative table of introduction
+// points; callers may append their own entries or load them with
+// LoadFeatures.
+var KnownFeatures = []Feature{
+	{Name: "Subsystem Reference block", Introduced: "R2019b", BlockType: "SubsystemReference"},
+	{Name: "Variant Assembly Subsystem block", Introduced: "R2023a", BlockType: "SubSystem", Parameter: "VariantChoicesSpecifier"},
+}
+
+// Incompatibility is a use of a Feature found in a model that the target
+// release predates. Count is 0 for a .mdl model, whose blocks are not
+// scanned: it was saved in a release that has the feature, so may use it.
+type Incompatibility struct {
+	Feature Feature `json:"feature"`
+	File    string  `json:"file"`
+	Count   int     `json:"count"`
+}
+
+// ModelFilePrefix is where an SLX archive keeps its block diagram XML.
+const ModelFilePrefix = "simulink/"
+
+// CheckCompatibility scans the block diagram XML of slx for features
+// introduced after target and reports each one found, per archive entry.
+// Only features with a BlockType or Parameter can be detected. A .mdl
+// model is checked by the release on its Version line instead, see
+// Incompatibility. slx is not modified.
+func CheckCompatibility(slx, target string, features []Feature) ([]Incompatibility, error) {
+	dst, err := ParseRelease(target)
+	if err != nil {
+		return nil, err
+	}
+	var gated []Feature
+	for _, f := range features {
+		intro, err := ParseRelease(f.Introduced)
+		if err == nil && f.detectable() && intro.Compare(dst) > 0 {
+			gated = append(gated, f)
+		}
+	}
+
+	if IsMDL(slx) {
+		return mdlCompatibility(slx, target, gated)
+	}
+	r, closeInput, err := openInput(context.Background(), slx, Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+
+	var found []Incompatibility
+	if len(gated) == 0 {
+		return found, nil
+	}
+	for _, f := range r.File {
+		if !strings.HasPrefix(f.Name, ModelFilePrefix) || !strings.HasSuffix(f.Name, ".xml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		doc := etree.NewDocument()
+		_, err = doc.ReadFrom(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		for _, feat := range gated {
+			if n := feat.count(doc); n > 0 {
+				found = append(found, Incompatibility{Feature: feat, File: f.Name, Count: n})
+			}
+		}
+	}
+	return found, nil
+}
+
+// mdlCompatibility is CheckCompatibility for the .mdl model slx: each of
+// gated that the release it was saved in already has is reported for the
+// whole file.
+func mdlCompatibility(slx, target string, gated []Feature) ([]Incompatibility, error) {
+	found, err := DetectMDL(slx, VersionTags)
+	if err != nil {
+		return nil, err
+	}
+	var issues []Incompatibility
+	for _, f := range DowngradeRisks(PrimaryRelease(found), target, gated) {
+		issues = append(issues, Incompatibility{Feature: f, File: filepath.Base(slx)})
+	}
+	return issues, nil
+}
+
+// DowngradeRisks returns the features that a conversion from release from
+// to release to would cross downwards, i.e. those introduced after to but
+// no later than from. Upgrades, unparsable releases and malformed table
+// entries yield no risks.
+func DowngradeRisks(from, to string, features []Feature) []Feature {
+	src, err := ParseRelease(from)
+	if err != nil {
+		return nil
+	}
+	dst, err := ParseRelease(to)
+	if err != nil || dst.Compare(src) >= 0 {
+		return nil
+	}
+
+	var risks []Feature
+	for _, f := range features {
+		intro, err := ParseRelease(f.Introduced)
+		if err != nil {
+			continue
+		}
+		if intro.Compare(dst) > 0 && intro.Compare(src) <= 0 {
+			risks = append(risks, f)
+		}
+	}
+	return risks
+}
+
+// LoadFeatures reads a JSON array of Feature objects from path, e.g.
+// [{"name": "My block", "introduced": "R2024a", "blockType": "MyBlock"}].
+func LoadFeatures(path string) ([]Feature, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var features []Feature
+	if err := json.Unmarshal(data, &features); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, f := range features {
+		if _, err := ParseRelease(f.Introduced); err != nil {
+			return nil, fmt.Errorf("%s: feature %q: %w", path, f.Name, err)
+		}
+	}
+	return features, nil
+}
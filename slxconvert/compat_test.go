@@ -0,0 +1,82 @@
+package slxconvert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.slx")
+	writeFixture(t, path, []fixtureEntry{
+		{Name: "metadata/coreProperties.xml", Body: "<cp><version>R2024b</version></cp>"},
+		{Name: "simulink/blockdiagram.xml", Body: `<Model><System><Block BlockType="New" Name="a"/><Block BlockType="Gain"/></System></Model>`},
+		{Name: "simulink/systems/system_1.xml", Body: `<System><Block BlockType="New"/><Block BlockType="New"/></System>`},
+	})
+	features := []Feature{
+		{Name: "new block", Introduced: "R2024a", BlockType: "New"},
+		{Name: "gain", Introduced: "R2000a", BlockType: "Gain"},
+		{Name: "untyped", Introduced: "R2024b"},
+	}
+
+	got, err := CheckCompatibility(path, "R2023b", features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Incompatibility{
+		{Feature: features[0], File: "simulink/blockdiagram.xml", Count: 1},
+		{Feature: features[0], File: "simulink/systems/system_1.xml", Count: 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("issue %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if got, _ := CheckCompatibility(path, "R2024a", features); len(got) != 0 {
+		t.Errorf("R2024a: got %+v, want none", got)
+	}
+}
+
+func TestCheckCompatibilityKnownFeatures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.slx")
+	writeFixture(t, path, []fixtureEntry{
+		{Name: "metadata/coreProperties.xml", Body: "<cp><version>R2024b</version></cp>"},
+		{Name: "simulink/blockdiagram.xml", Body: `<Model><System>
+<Block BlockType="SubSystem" Name="assembly"><P Name="Variant">on</P><P Name="VariantChoicesSpecifier">{'Controllers/*'}</P></Block>
+<Block BlockType="SubSystem" Name="plain"><P Name="Variant">on</P></Block>
+</System></Model>`},
+	})
+
+	got, err := CheckCompatibility(path, "R2022b", KnownFeatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Feature.Name != "Variant Assembly Subsystem block" || got[0].Count != 1 {
+		t.Errorf("R2022b: got %+v, want the Variant Assembly Subsystem once", got)
+	}
+	if got, _ := CheckCompatibility(path, "R2023a", KnownFeatures); len(got) != 0 {
+		t.Errorf("R2023a: got %+v, want none", got)
+	}
+}
+
+func TestCheckCompatibilityMDL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.mdl")
+	if err := os.WriteFile(path, []byte("Model {\n  Name\t\"demo\"\n  Version\t23.2\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CheckCompatibility(path, "R2022b", KnownFeatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Feature.Name != "Variant Assembly Subsystem block" || got[0].File != "model.mdl" || got[0].Count != 0 {
+		t.Errorf("R2022b: got %+v, want the Variant Assembly Subsystem for the whole file", got)
+	}
+	if got, err := CheckCompatibility(path, "R2023a", KnownFeatures); err != nil || len(got) != 0 {
+		t.Errorf("R2023a: got %+v, %v; want none", got, err)
+	}
+}
@@ -0,0 +1,922 @@
+// Package slxconvert retargets Simulink archives (.slx, .sldd, .mldatx) to
+// a different MATLAB release by rewriting the release strings stored in
+// their metadata XML.
+package slxconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// Options controls how Convert rewrites an archive.
+type Options struct {
+	// DryRun reports the tags that would change without writing anything.
+	DryRun bool
+	// InMemory converts without extracting to a temporary directory,
+	// streaming each entry straight into the new archive. This is also
+	// what happens without it unless Store, CompressionLevel or
+	// KeepWorkDir calls for extraction: entries other than the metadata
+	// are then copied as they are, without being decompressed at all.
+	InMemory bool
+	// PreserveMtime keeps the input's modification time on the output.
+	PreserveMtime bool
+	// Force allows an existing output (or backup) other than the input to be overwritten.
+	Force bool
+	// Backup copies the input to <input>.bak before converting.
+	Backup bool
+	// CompressionLevel is the flate level (1-9) for deflated entries;
+	// zero selects flate.DefaultCompression.
+	CompressionLevel int
+	// Store writes every entry uncompressed, ignoring CompressionLevel.
+	Store bool
+	// DowngradeOnly refuses conversions to a newer release than the input's.
+	DowngradeOnly bool
+	// UpgradeOnly refuses conversions to an older release than the input's.
+	UpgradeOnly bool
+	// Verify reopens the written archive and checks that its version tags
+	// read the target release. It is ignored by ConvertStream.
+	Verify bool
+	// ExtraTags are element names to rewrite in addition to VersionTags.
+	// An entry of the form "element@attr" rewrites that attribute of the
+	// element instead of its text, e.g. "coreProperty@release". A plain
+	// name matches in any namespace; "prefix:element" and "{uri}element"
+	// narrow it to one (see findTag).
+	ExtraTags []string
+	// TextTags are element (or element@attr) names whose text mentions a
+	// release in passing, such as a description reading "saved with
+	// MATLAB R2024b". Rather than the whole text, every release string
+	// within it is replaced by the target. SkipTags apply to them too, but
+	// .mdl models are left alone.
+	TextTags []string
+	// Retries is how many times a conversion that failed with a transient
+	// file system error (EIO, ESTALE, a timeout, ...) is attempted again.
+	Retries int
+	// Checksum records the SHA-256 of the input and output in the Result.
+	// The output is hashed as it is written.
+	Checksum bool
+	// WorkDir is where temporary extraction directories and spooled
+	// streams are created; empty means os.TempDir(). Atomic output files
+	// are always created next to the output.
+	WorkDir string
+	// StripExtra drops the archive comment and the entries' extra fields
+	// and comments instead of copying them from the input.
+	StripExtra bool
+	// KeepWorkDir leaves the extracted tree of an on-disk conversion in
+	// place for inspection, whether or not the conversion succeeds, and
+	// reports it in Result.WorkDir.
+	KeepWorkDir bool
+	// ForceWritable lets a conversion replace a read-only output, as left
+	// by VCS checkouts that lock files. The output gets its original
+	// permissions back afterwards. Without it such outputs fail with
+	// ErrReadOnly.
+	ForceWritable bool
+	// SkipTags exempts tags in particular metadata files from rewriting,
+	// for files where an element of the same name means something else,
+	// such as a schema version. Each entry is "file:tag", e.g.
+	// "coreProperties.xml:version", or just "file" to leave the whole file
+	// alone. file matches an entry name or its trailing path elements, as
+	// MetadataFiles do.
+	SkipTags []string
+	// MaxEntrySize and MaxTotalSize cap the uncompressed size of any one
+	// entry and of all entries of an archive, which guards against zip
+	// bombs in untrusted input. Zero selects DefaultMaxEntrySize and
+	// DefaultMaxTotalSize; a negative value removes the limit. Archives
+	// over a limit fail with ErrTooLarge before anything is extracted.
+	MaxEntrySize int64
+	MaxTotalSize int64
+	// Logger, if set, receives a line for each step of the conversion.
+	Logger *log.Logger
+
+	// outputHash, when set, sees every byte written by newZipWriter.
+	outputHash hash.Hash
+	// archiveComment is the comment newZipWriter gives the new archive.
+	archiveComment string
+	// normalize is set by Normalize: the archive is rewritten with no
+	// release and no tags to update.
+	normalize bool
+	// extract forces the on-disk conversion where entries could be copied
+	// as they are, so that tests cover both paths.
+	extract bool
+}
+
+func (o Options) logf(format string, args ...any) {
+	if o.Logger != nil {
+		o.Logger.Printf(format, args...)
+	}
+}
+
+// entryMethod returns the compression method for an entry originally
+// stored with method orig.
+func (o Options) entryMethod(orig uint16) uint16 {
+	if o.Store {
+		return zip.Store
+	}
+	return orig
+}
+
+// recompresses reports whether every entry has to be decompressed and
+// compressed again: to store it or deflate it at a chosen level, or to
+// rezip a damaged archive for Normalize. Otherwise entries other than the
+// metadata are copied compressed, which is most of the work saved on a
+// large model.
+func (o Options) recompresses() bool {
+	return o.Store || o.CompressionLevel != 0 || o.normalize
+}
+
+// tags returns VersionTags followed by any ExtraTags not already present.
+func (o Options) tags() []string {
+	tags := append([]string(nil), VersionTags...)
+	for _, extra := range o.ExtraTags {
+		dup := false
+		for _, t := range tags {
+			if t == extra {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			tags = append(tags, extra)
+		}
+	}
+	return tags
+}
+
+// tagsFor returns tags less those SkipTags exempts in the metadata file
+// name.
+func (o Options) tagsFor(name string) []string {
+	if o.normalize {
+		return nil
+	}
+	var tags []string
+	for _, tag := range o.tags() {
+		if !o.skipsTag(name, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// textTagsFor returns the TextTags not exempted by SkipTags in the
+// metadata file name.
+func (o Options) textTagsFor(name string) []string {
+	if o.normalize {
+		return nil
+	}
+	var tags []string
+	for _, tag := range o.TextTags {
+		if !o.skipsTag(name, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// updatesFor maps every tag rewritten in the metadata file name, including
+// its TextTags, to release.
+func (o Options) updatesFor(name, release string) map[string]string {
+	return releaseUpdates(release, append(o.tagsFor(name), o.textTagsFor(name)...))
+}
+
+// isTextTag reports whether tag is one of TextTags.
+func (o Options) isTextTag(tag string) bool {
+	for _, t := range o.TextTags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseToken matches a release string within a longer text.
+var releaseToken = regexp.MustCompile(`\bR20\d\d[ab]\b`)
+
+// newValue returns what the value old of tag becomes when rewritten to
+// release: release itself, or for a TextTags element old with each
+// release string in it replaced.
+func (o Options) newValue(tag, old, release string) string {
+	if o.isTextTag(tag) {
+		return releaseToken.ReplaceAllString(old, release)
+	}
+	return release
+}
+
+// skipsTag reports whether SkipTags exempts tag in the file name.
+func (o Options) skipsTag(name, tag string) bool {
+	for _, skip := range o.SkipTags {
+		file, t, ok := strings.Cut(skip, ":")
+		if (!ok || t == tag) && (name == file || strings.HasSuffix(name, "/"+file)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Result describes a completed conversion.
+type Result struct {
+	Input   string
+	Output  string
+	Changes []TagChange
+	// OriginalRelease is the release the input was at before conversion,
+	// as PrimaryRelease reports it; empty if none could be read.
+	OriginalRelease string
+	// Skipped is set when the input was already at the target release and
+	// was left untouched.
+	Skipped bool
+	// BadMetadata lists metadata files that could not be parsed and were
+	// copied through unchanged.
+	BadMetadata []MetadataError
+	// MetadataEntries are the metadata files found in the input. Entries
+	// outside the archive root suggest it was re-zipped with an extra
+	// top-level folder.
+	MetadataEntries []string
+	// InputSHA256 and OutputSHA256 are hex digests filled in when
+	// Options.Checksum is set. OutputSHA256 is empty for dry runs and
+	// skipped files.
+	InputSHA256  string
+	OutputSHA256 string
+	// WorkDir is the extracted tree left behind by Options.KeepWorkDir.
+	WorkDir string
+	// Backup is the copy of the input made for Options.Backup.
+	Backup string
+	// NonASCIINames lists input entries whose names are not plain ASCII.
+	// They are written as UTF-8 bytes without the UTF-8 flag, which MATLAB
+	// may show garbled or refuse to open.
+	NonASCIINames []string
+}
+
+// MaxMetadataSize caps how much of a metadata entry is read into memory
+// for parsing; every other entry is streamed.
+const MaxMetadataSize = 64 << 20
+
+// Convert rewrites the release metadata of input to release and writes the
+// archive to output, which may be the same path as input.
+func Convert(input, output, release string, opts Options) (Result, error) {
+	return ConvertContext(context.Background(), input, output, release, opts)
+}
+
+// ConvertContext is Convert with cancellation: once ctx is done the
+// conversion stops at the next entry (or read within an entry) and
+// returns ctx.Err().
+func ConvertContext(ctx context.Context, input, output, release string, opts Options) (Result, error) {
+	opts.normalize = false
+	if _, err := ParseRelease(release); err != nil {
+		return Result{Input: input, Output: output}, err
+	}
+	return convert(ctx, input, output, release, opts)
+}
+
+// Normalize rewrites the archive input to output through the
+// MATLABZipWriter without touching its metadata, which rescues models that
+// another zip tool saved in a way MATLAB cannot open. Entries come out
+// deflated (or stored), with forward-slash names and no UTF-8 flag.
+func Normalize(input, output string, opts Options) (Result, error) {
+	return NormalizeContext(context.Background(), input, output, opts)
+}
+
+// NormalizeContext is Normalize with cancellation, as for ConvertContext.
+func NormalizeContext(ctx context.Context, input, output string, opts Options) (Result, error) {
+	if IsMDL(typedPath(input, output)) {
+		return Result{Input: input, Output: output}, fmt.Errorf("%s: only zip archives can be normalized", input)
+	}
+	opts.normalize = true
+	return convert(ctx, input, output, "", opts)
+}
+
+// convert is ConvertContext and NormalizeContext once the release has been
+// checked.
+func convert(ctx context.Context, input, output, release string, opts Options) (Result, error) {
+	res := Result{Input: input, Output: output}
+
+	// refuse to clobber something other than the input unless forced
+	_, statErr := os.Stat(output)
+	outExisted := statErr == nil
+	if filepath.Clean(output) != filepath.Clean(input) && outExisted && !opts.Force {
+		return res, fmt.Errorf("output %s %w", output, ErrOutputExists)
+	}
+
+	// remember the original timestamp so metadata-only changes don't bump it
+	srcInfo, err := os.Stat(input)
+	if err != nil {
+		return res, err
+	}
+
+	mdl := IsMDL(typedPath(input, output))
+	wrapped := IsGzip(input) || IsGzip(output)
+	switch {
+	case mdl && wrapped:
+		return res, fmt.Errorf("%s: gzipped .mdl models are not supported", input)
+	case mdl:
+		err = checkMDL(input, output, release, &res, opts)
+	default:
+		err = checkInputArchive(ctx, input, output, release, &res, &opts)
+	}
+	if err != nil {
+		return res, err
+	}
+	// a backup reads the whole input anyway, so the input is hashed on the
+	// way through rather than read just for its checksum
+	backingUp := opts.Backup && !opts.DryRun && !res.Skipped
+	if opts.Checksum {
+		if !backingUp {
+			if res.InputSHA256, err = HashFile(input); err != nil {
+				return res, err
+			}
+		}
+		opts.outputHash = sha256.New()
+	}
+	if res.Skipped {
+		return res, nil
+	}
+
+	if info, err := os.Stat(output); err == nil && info.Mode().Perm()&0o200 == 0 && !opts.DryRun {
+		if !opts.ForceWritable {
+			return res, fmt.Errorf("output %s %w", output, ErrReadOnly)
+		}
+		perm := info.Mode().Perm()
+		if err := os.Chmod(output, perm|0o200); err != nil {
+			return res, err
+		}
+		opts.logf("made %s writable", output)
+		defer os.Chmod(output, perm)
+	}
+
+	if backingUp {
+		err := withRetry(ctx, opts, "backup "+input, func() error {
+			var err error
+			res.InputSHA256, err = backupFile(input, opts)
+			return err
+		})
+		if err != nil {
+			return res, err
+		}
+		res.Backup = input + ".bak"
+		if !opts.Checksum {
+			res.InputSHA256 = ""
+		}
+	}
+
+	// both paths leave the input untouched until they succeed, so a failed
+	// attempt can simply be run again
+	err = withRetry(ctx, opts, "convert "+input, func() error {
+		if opts.outputHash != nil {
+			opts.outputHash.Reset()
+		}
+		var err error
+		if mdl {
+			res.Changes, err = convertMDL(input, output, release, opts)
+			return err
+		}
+		if wrapped {
+			res.Changes, res.BadMetadata, err = convertGzip(ctx, input, output, release, opts)
+			return err
+		}
+		// extracting only pays off when every entry is recompressed, or
+		// when the tree is wanted for inspection
+		if opts.InMemory || !opts.recompresses() && !opts.KeepWorkDir && !opts.extract {
+			res.Changes, res.BadMetadata, err = convertInMemory(ctx, input, output, release, opts)
+			return err
+		}
+		workDir, err := newWorkDir(input, opts)
+		if err != nil {
+			return err
+		}
+		if opts.KeepWorkDir {
+			keepWorkDir(workDir)
+			res.WorkDir = workDir
+			opts.logf("keeping %s", workDir)
+		} else {
+			defer removeWorkDir(workDir)
+		}
+		res.Changes, res.BadMetadata, err = convertOnDisk(ctx, workDir, input, output, release, opts)
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+
+	if opts.DryRun {
+		// nothing is written in dry-run mode
+		return res, nil
+	}
+	if opts.outputHash != nil {
+		res.OutputSHA256 = hex.EncodeToString(opts.outputHash.Sum(nil))
+	}
+	if opts.Verify && opts.normalize {
+		// there is no release to check, but the output must still open
+		_, closeOutput, err := openInput(ctx, output, opts)
+		if err != nil {
+			return res, fmt.Errorf("verify %s: %w", output, err)
+		}
+		closeOutput()
+		opts.logf("verified %s", output)
+	} else if opts.Verify {
+		if err := Verify(output, release, opts); err != nil {
+			return res, err
+		}
+		opts.logf("verified %s", output)
+	}
+	if opts.PreserveMtime {
+		if err := os.Chtimes(output, time.Time{}, srcInfo.ModTime()); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// checkInputArchive inspects the archive input before conversion: it must
+// carry version tags, the conversion direction must be allowed, and
+// res.Skipped is set if an in-place conversion would change nothing. The
+// archive comment is kept in opts for the new archive.
+func checkInputArchive(ctx context.Context, input, output, release string, res *Result, opts *Options) error {
+	zr, closeInput, err := openInput(ctx, input, *opts)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+	files := MetadataFilesFor(filepath.Ext(typedPath(input, output)))
+	res.MetadataEntries = metadataEntries(zr, files)
+	opts.logf("%d metadata file(s) found", len(res.MetadataEntries))
+	res.NonASCIINames = nonASCIINames(zr, *opts)
+	if err := checkSizes(zr, *opts); err != nil {
+		return err
+	}
+	if !opts.StripExtra {
+		opts.archiveComment = zr.Comment
+	}
+	if opts.normalize {
+		// the archive is only rewritten, so its metadata need not be
+		// readable
+		if found, err := detectReader(zr, files, VersionTags); err == nil {
+			res.OriginalRelease = PrimaryRelease(found)
+		}
+		return nil
+	}
+	found, err := checkArchive(zr, files, opts.tags())
+	res.OriginalRelease = PrimaryRelease(found)
+	if err == nil {
+		err = checkDirection(res.OriginalRelease, release, *opts)
+	}
+	if err == nil && filepath.Clean(output) == filepath.Clean(input) {
+		// rewriting an archive that is already at release would only churn
+		// its bytes and timestamp
+		res.Skipped, err = atRelease(zr, files, release, *opts)
+	}
+	return err
+}
+
+// typedPath returns the path whose extension decides how input is
+// treated: input itself, or output if input has no extension, so that a
+// model saved without one can still be converted by naming the output.
+// A GzipExt is looked through.
+func typedPath(input, output string) string {
+	if input = Unwrapped(input); filepath.Ext(input) == "" {
+		return Unwrapped(output)
+	}
+	return input
+}
+
+// checkMDL is checkInputArchive for a .mdl model.
+func checkMDL(input, output, release string, res *Result, opts Options) error {
+	found, err := DetectMDL(input, opts.tags())
+	res.OriginalRelease = PrimaryRelease(found)
+	if err == nil {
+		err = checkDirection(res.OriginalRelease, release, opts)
+	}
+	if err == nil && filepath.Clean(output) == filepath.Clean(input) {
+		var data []byte
+		if data, err = os.ReadFile(input); err == nil {
+			var changes []TagChange
+			_, changes, err = rewriteMDL(data, filepath.Base(input), release, opts.tagsFor(filepath.Base(input)))
+			res.Skipped = err == nil && len(changes) == 0
+		}
+	}
+	return err
+}
+
+// HashFile returns the hex SHA-256 digest of the file at path, in the form
+// of Result.InputSHA256 and OutputSHA256.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkDirection enforces opts.DowngradeOnly and opts.UpgradeOnly for a
+// conversion from current to target.
+func checkDirection(current, target string, opts Options) error {
+	if !opts.DowngradeOnly && !opts.UpgradeOnly {
+		return nil
+	}
+	cmp, err := CompareReleases(target, current)
+	if err != nil {
+		return fmt.Errorf("cannot check conversion direction: %w", err)
+	}
+	if opts.DowngradeOnly && cmp > 0 {
+		return fmt.Errorf("%w: refusing to upgrade from %s to %s (downgrade-only)", ErrWrongDirection, current, target)
+	}
+	if opts.UpgradeOnly && cmp < 0 {
+		return fmt.Errorf("%w: refusing to downgrade from %s to %s (upgrade-only)", ErrWrongDirection, current, target)
+	}
+	return nil
+}
+
+// backupFile copies slx to slx+".bak", refusing to replace an existing
+// backup unless opts.Force is set, and returns the SHA-256 of slx. A
+// backup that already holds slx as it is, such as one left by an attempt
+// that failed later on, is kept, so that the step can be retried. The copy
+// is written with WriteAtomic, so a failed one leaves no partial backup
+// behind.
+func backupFile(slx string, opts Options) (string, error) {
+	bak := slx + ".bak"
+	if _, err := os.Stat(bak); err == nil && !opts.Force {
+		want, err := HashFile(slx)
+		if err != nil {
+			return "", err
+		}
+		if got, err := HashFile(bak); err != nil || got != want {
+			return "", fmt.Errorf("backup %s %w", bak, ErrOutputExists)
+		}
+		return want, nil
+	}
+
+	in, err := os.Open(slx)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	h := sha256.New()
+	err = WriteAtomic(bak, func(w io.Writer) error {
+		_, err := io.Copy(w, io.TeeReader(in, h))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newWorkDir creates and tracks an empty directory under opts.WorkDir to
+// extract slx into. A unique directory keeps concurrent runs from
+// colliding and never leaves stale folders next to the input.
+func newWorkDir(slx string, opts Options) (string, error) {
+	base := strings.TrimSuffix(filepath.Base(slx), filepath.Ext(slx))
+	dir, err := os.MkdirTemp(opts.WorkDir, base+"_unzipped")
+	if err != nil {
+		return "", err
+	}
+	trackWorkDir(dir)
+	return dir, nil
+}
+
+// workDirPattern matches the names given to the temporary directories of
+// newWorkDir, ExtractBatch and SelfTest, as well as the fixed
+// "<base>_unzipped" folders that older versions created next to the input.
+var workDirPattern = regexp.MustCompile(`(_unzipped|^slxconvert_(batch|selftest))[0-9]*$`)
+
+// IsWorkDirName reports whether name looks like a temporary directory
+// created by a conversion, such as one left behind by a crashed run.
+func IsWorkDirName(name string) bool {
+	return workDirPattern.MatchString(name)
+}
+
+// convertOnDisk extracts slx to the empty directory workDir, rewrites the
+// metadata and zips the tree back up into outSLX. Metadata files that fail
+// to parse are left as they are and returned alongside the changes.
+func convertOnDisk(ctx context.Context, workDir, slx, outSLX, release string, opts Options) ([]TagChange, []MetadataError, error) {
+	manifest, err := Unzip(ctx, slx, workDir, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var names []string
+	if !opts.normalize {
+		names, err = MetadataPaths(workDir, filepath.Ext(typedPath(slx, outSLX)))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var all []TagChange
+	var bad []MetadataError
+	parsed := 0
+	for _, name := range names {
+		opts.logf("found %s", name)
+		updates := opts.updatesFor(name, release)
+		changes, err := UpdateVersions(filepath.Join(workDir, filepath.FromSlash(name)), updates, opts)
+		if err != nil {
+			opts.logf("%s: skipped: %v", name, err)
+			bad = append(bad, MetadataError{File: name, Err: err})
+			continue
+		}
+		parsed++
+		opts.logf("%s: %d tag(s) modified", name, len(changes))
+		all = append(all, stampFile(name, changes)...)
+	}
+	if parsed == 0 && len(bad) > 0 {
+		return nil, bad, bad[0]
+	}
+
+	if opts.DryRun {
+		return all, bad, nil
+	}
+	return all, bad, ZipDir(ctx, workDir, outSLX, manifest, opts)
+}
+
+// activeWorkDirs holds the temporary directories of in-flight conversions
+// so they can be removed if the process is interrupted.
+var (
+	activeWorkDirsMu sync.Mutex
+	activeWorkDirs   = make(map[string]struct{})
+)
+
+func trackWorkDir(dir string) {
+	activeWorkDirsMu.Lock()
+	defer activeWorkDirsMu.Unlock()
+	activeWorkDirs[dir] = struct{}{}
+}
+
+// removeWorkDir deletes dir and stops tracking it.
+func removeWorkDir(dir string) {
+	activeWorkDirsMu.Lock()
+	defer activeWorkDirsMu.Unlock()
+	os.RemoveAll(dir)
+	delete(activeWorkDirs, dir)
+}
+
+// keepWorkDir stops tracking dir without removing it, so that it also
+// survives CleanupWorkDirs.
+func keepWorkDir(dir string) {
+	activeWorkDirsMu.Lock()
+	defer activeWorkDirsMu.Unlock()
+	delete(activeWorkDirs, dir)
+}
+
+// CleanupWorkDirs removes the temporary directories of any conversions
+// still in flight. It is meant to be called from a signal handler just
+// before the process exits; no further conversions should be started.
+func CleanupWorkDirs() {
+	activeWorkDirsMu.Lock()
+	for dir := range activeWorkDirs {
+		os.RemoveAll(dir)
+	}
+}
+
+// convertInMemory rewrites slx into outSLX without creating a temporary
+// directory: entries are streamed one at a time from slx to the new
+// archive, with only the metadata held in memory. The archive is written
+// with WriteAtomic, so outSLX may be slx itself.
+func convertInMemory(ctx context.Context, slx, outSLX, release string, opts Options) ([]TagChange, []MetadataError, error) {
+	zr, err := openArchive(slx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+	files := MetadataFilesFor(filepath.Ext(typedPath(slx, outSLX)))
+
+	if opts.DryRun {
+		return rewriteArchive(ctx, &zr.Reader, io.Discard, files, release, opts)
+	}
+
+	var changes []TagChange
+	var bad []MetadataError
+	err = WriteAtomic(outSLX, func(w io.Writer) error {
+		var err error
+		changes, bad, err = rewriteArchive(ctx, &zr.Reader, w, files, release, opts)
+		// the input has to be closed before it can be replaced on Windows
+		zr.Close()
+		return err
+	})
+	if err != nil {
+		return nil, bad, err
+	}
+	return changes, bad, nil
+}
+
+// spoolInput returns r as a zip.Reader. Regular files are read in place;
+// anything else (a pipe, say) is first copied to a temporary file in dir
+// rather than into memory. If h is not nil the input bytes are also
+// written to it. The returned cleanup must be called once done.
+func spoolInput(ctx context.Context, r io.Reader, dir string, h io.Writer) (*zip.Reader, func(), error) {
+	if f, ok := r.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			if h != nil {
+				if _, err := io.Copy(h, io.NewSectionReader(f, 0, info.Size())); err != nil {
+					return nil, nil, err
+				}
+			}
+			zr, err := zip.NewReader(f, info.Size())
+			return zr, func() {}, err
+		}
+	}
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+
+	tmp, err := os.CreateTemp(dir, "slxconvert_stream")
+	if err != nil {
+		return nil, nil, err
+	}
+	trackWorkDir(tmp.Name())
+	cleanup := func() {
+		tmp.Close()
+		removeWorkDir(tmp.Name())
+	}
+	n, err := io.Copy(tmp, ctxReader{ctx, r})
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	zr, err := zip.NewReader(tmp, n)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return zr, cleanup, nil
+}
+
+// ConvertStream reads a whole archive from r, rewrites its release
+// metadata to release and writes the new archive to w. Nothing is written
+// to w in dry-run mode. Because ZIP archives must be read from the end, a
+// non-seekable r is spooled to a temporary file first. With no file name
+// to go by, the default MetadataFiles are used.
+func ConvertStream(r io.Reader, w io.Writer, release string, opts Options) (Result, error) {
+	return ConvertStreamContext(context.Background(), r, w, release, opts)
+}
+
+// ConvertStreamContext is ConvertStream with cancellation, as for
+// ConvertContext.
+func ConvertStreamContext(ctx context.Context, r io.Reader, w io.Writer, release string, opts Options) (Result, error) {
+	var res Result
+	if _, err := ParseRelease(release); err != nil {
+		return res, err
+	}
+
+	var inHash hash.Hash
+	if opts.Checksum {
+		inHash = sha256.New()
+		opts.outputHash = sha256.New()
+	}
+	zr, cleanup, err := spoolInput(ctx, r, opts.WorkDir, inHash)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer cleanup()
+	if inHash != nil {
+		res.InputSHA256 = hex.EncodeToString(inHash.Sum(nil))
+	}
+	found, err := checkArchive(zr, MetadataFiles, opts.tags())
+	res.MetadataEntries = metadataEntries(zr, MetadataFiles)
+	res.NonASCIINames = nonASCIINames(zr, opts)
+	res.OriginalRelease = PrimaryRelease(found)
+	if err == nil {
+		err = checkSizes(zr, opts)
+	}
+	if err != nil {
+		return res, err
+	}
+	if err := checkDirection(res.OriginalRelease, release, opts); err != nil {
+		return res, err
+	}
+
+	if opts.DryRun {
+		w = io.Discard
+	}
+	res.Changes, res.BadMetadata, err = rewriteArchive(ctx, zr, w, MetadataFiles, release, opts)
+	if err == nil && opts.outputHash != nil && !opts.DryRun {
+		res.OutputSHA256 = hex.EncodeToString(opts.outputHash.Sum(nil))
+	}
+	return res, err
+}
+
+// rewriteArchive copies every entry of zr into a new MATLAB-compatible
+// archive written to w, updating the metadata entries files on the way
+// through.
+// A metadata entry that does not parse is copied unchanged and reported;
+// the rewrite only fails if none of the metadata entries could be read.
+func rewriteArchive(ctx context.Context, zr *zip.Reader, w io.Writer, files []string, release string, opts Options) ([]TagChange, []MetadataError, error) {
+	var all []TagChange
+	var bad []MetadataError
+	parsed := 0
+
+	if !opts.StripExtra {
+		opts.archiveComment = zr.Comment
+	}
+	zw := newZipWriter(w, opts)
+	budget := newSizeBudget(opts)
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return nil, bad, err
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		meta := isMetadataFile(f.Name, files) && !opts.normalize
+		if !meta && copiesRaw(f, opts) {
+			if err := copyRawEntry(ctx, zw, f, opts); err != nil {
+				return nil, bad, err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, bad, err
+		}
+		src := budget.reader(f.Name, rc)
+		var buf *bytes.Buffer
+		if meta {
+			// metadata entries are small, so buffer them for parsing
+			opts.logf("found %s", f.Name)
+			buf = getBuffer()
+			_, err := buf.ReadFrom(io.LimitReader(src, MaxMetadataSize+1))
+			if err == nil && buf.Len() > MaxMetadataSize {
+				err = fmt.Errorf("%s: %w (over %d bytes)", f.Name, ErrMetadataTooLarge, MaxMetadataSize)
+			}
+			if err != nil {
+				putBuffer(buf)
+				rc.Close()
+				return nil, bad, err
+			}
+			doc := etree.NewDocument()
+			if err := doc.ReadFromBytes(buf.Bytes()); err != nil {
+				opts.logf("%s: skipped: %v", f.Name, err)
+				bad = append(bad, MetadataError{File: f.Name, Err: err})
+			} else {
+				parsed++
+				updates := opts.updatesFor(f.Name, release)
+				changes := stampFile(f.Name, updateDocument(doc, updates, opts))
+				opts.logf("%s: %d tag(s) modified", f.Name, len(changes))
+				all = append(all, changes...)
+				if len(changes) > 0 {
+					buf.Reset()
+					if _, err := doc.WriteTo(buf); err != nil {
+						putBuffer(buf)
+						rc.Close()
+						return nil, bad, err
+					}
+				}
+			}
+			src = buf
+		}
+
+		opts.logf("write %s", f.Name)
+		header := newEntryHeader(f.Name, f.Modified, opts.entryMethod(f.Method), f.Mode())
+		copyExtras(header, f.Extra, f.Comment, opts)
+		dst, err := zw.CreateHeader(header)
+		if err == nil {
+			_, err = io.Copy(dst, ctxReader{ctx, src})
+		}
+		rc.Close()
+		if buf != nil {
+			putBuffer(buf)
+		}
+		if err != nil {
+			return nil, bad, err
+		}
+	}
+	if parsed == 0 && len(bad) > 0 {
+		return nil, bad, bad[0]
+	}
+	if err := zw.Close(); err != nil {
+		return nil, bad, err
+	}
+	return all, bad, nil
+}
+
+// copyRawEntry copies f into zw still compressed. Nothing is inflated, so
+// the size budget has nothing to count; checkSizes has already held the
+// declared sizes to the limits, and the copy is no bigger than the input.
+func copyRawEntry(ctx context.Context, zw *MATLABZipWriter, f *zip.File, opts Options) error {
+	opts.logf("copy %s", f.Name)
+	rc, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	dst, err := zw.CreateRaw(rawHeader(f, opts))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, ctxReader{ctx, rc})
+	return err
+}
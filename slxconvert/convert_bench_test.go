@@ -0,0 +1,159 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBenchArchive creates an SLX-like archive at path whose single
+// payload entry holds size bytes of random text, which deflates about as
+// well as a model's XML does, compressed with method.
+func writeBenchArchive(tb testing.TB, path string, size int64, method uint16) {
+	tb.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("metadata/coreProperties.xml")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	io.WriteString(w, "<cp><version>R2024a</version></cp>")
+
+	w, err = zw.CreateHeader(&zip.FileHeader{Name: "simulink/payload.bin", Method: method})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := io.CopyN(w, benchText{rand.New(rand.NewSource(1))}, size); err != nil {
+		tb.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// benchText turns random bytes into text from a 16-letter alphabet.
+type benchText struct{ r io.Reader }
+
+func (t benchText) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	for i := range p[:n] {
+		p[i] = 'a' + p[i]%16
+	}
+	return n, err
+}
+
+// BenchmarkConvertInMemory converts archives of growing size. The
+// allocations per operation (B/op) should stay flat as the archive grows,
+// showing that entries are streamed rather than buffered.
+func BenchmarkConvertInMemory(b *testing.B) {
+	for _, mb := range []int64{4, 16, 64} {
+		b.Run(fmt.Sprintf("%dMB", mb), func(b *testing.B) {
+			dir := b.TempDir()
+			in := filepath.Join(dir, "in.slx")
+			out := filepath.Join(dir, "out.slx")
+			writeBenchArchive(b, in, mb<<20, zip.Store)
+			opts := Options{InMemory: true, Force: true, Store: true}
+
+			b.SetBytes(mb << 20)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Convert(in, out, "R2023b", opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkConvertStream is BenchmarkConvertInMemory for ConvertStream
+// reading from a non-seekable source, which is spooled to disk.
+func BenchmarkConvertStream(b *testing.B) {
+	for _, mb := range []int64{4, 16, 64} {
+		b.Run(fmt.Sprintf("%dMB", mb), func(b *testing.B) {
+			in := filepath.Join(b.TempDir(), "in.slx")
+			writeBenchArchive(b, in, mb<<20, zip.Store)
+			opts := Options{Store: true}
+
+			b.SetBytes(mb << 20)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := os.Open(in)
+				if err != nil {
+					b.Fatal(err)
+				}
+				// hide the *os.File so the input looks like a pipe
+				if _, err := ConvertStream(struct{ io.Reader }{f}, io.Discard, "R2023b", opts); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkConvertRaw compares copying a large deflated payload as it is
+// with decompressing and deflating it again, as a CompressionLevel forces.
+// Copying should run at close to disk speed.
+func BenchmarkConvertRaw(b *testing.B) {
+	modes := []struct {
+		name string
+		opts Options
+	}{
+		{"Copy", Options{InMemory: true, Force: true}},
+		{"Recompress", Options{InMemory: true, Force: true, CompressionLevel: flate.DefaultCompression}},
+	}
+	for _, mode := range modes {
+		for _, mb := range []int64{16, 64} {
+			b.Run(fmt.Sprintf("%s/%dMB", mode.name, mb), func(b *testing.B) {
+				dir := b.TempDir()
+				in := filepath.Join(dir, "in.slx")
+				out := filepath.Join(dir, "out.slx")
+				writeBenchArchive(b, in, mb<<20, zip.Deflate)
+
+				b.SetBytes(mb << 20)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := Convert(in, out, "R2023b", mode.opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRewriteMetadata converts a small model over and over, as a
+// directory run does, so that B/op is dominated by the read/modify/write
+// of the metadata files rather than by the payload.
+func BenchmarkRewriteMetadata(b *testing.B) {
+	for _, mode := range convertModes {
+		b.Run(mode.name, func(b *testing.B) {
+			dir := b.TempDir()
+			in := filepath.Join(dir, "in.slx")
+			out := filepath.Join(dir, "out.slx")
+			writeFixture(b, in, modelFixture)
+			opts := Options{InMemory: mode.inMemory, extract: !mode.inMemory, Force: true}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Convert(in, out, "R2023b", opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
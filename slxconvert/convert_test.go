@@ -0,0 +1,767 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// convertModes runs a conversion test against both the on-disk and the
+// in-memory code paths.
+var convertModes = []struct {
+	name     string
+	inMemory bool
+}{
+	{"OnDisk", false},
+	{"InMemory", true},
+}
+
+func TestConvertGolden(t *testing.T) {
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "model.slx")
+			out := filepath.Join(dir, "model_r2023b.slx")
+			writeFixture(t, in, modelFixture)
+
+			res, err := Convert(in, out, "R2023b", Options{InMemory: mode.inMemory, extract: !mode.inMemory, Verify: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Changes) != 3 {
+				t.Errorf("got %d changes, want 3: %+v", len(res.Changes), res.Changes)
+			}
+			if res.OriginalRelease != "R2024b" {
+				t.Errorf("OriginalRelease = %q, want R2024b", res.OriginalRelease)
+			}
+
+			headers, contents := readArchive(t, out)
+			checkMATLABCompatible(t, headers)
+			for _, name := range []string{"metadata/coreProperties.xml", "metadata/mwcoreProperties.xml"} {
+				checkGolden(t, "R2023b/"+name, contents[name])
+			}
+			for _, e := range modelFixture {
+				name := strings.ReplaceAll(e.Name, `\`, "/")
+				if !strings.HasPrefix(name, "metadata/") && contents[name] != e.Body {
+					t.Errorf("%s: content changed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertInPlace(t *testing.T) {
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "model.slx")
+			writeFixture(t, path, modelFixture)
+			opts := Options{InMemory: mode.inMemory, extract: !mode.inMemory}
+
+			if _, err := Convert(path, path, "R2023a", opts); err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(path, "R2023a", opts); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := Convert(path, path, "R2023a", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Skipped {
+				t.Error("second conversion to the same release was not skipped")
+			}
+
+			entries, _ := os.ReadDir(filepath.Dir(path))
+			if len(entries) != 1 {
+				t.Errorf("temporary files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestConvertBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.slx")
+	writeFixture(t, path, modelFixture)
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Convert(path, path, "R2023a", Options{Backup: true, Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Backup != path+".bak" {
+		t.Errorf("Backup = %q, want %q", res.Backup, path+".bak")
+	}
+	if bak, err := os.ReadFile(res.Backup); err != nil || !bytes.Equal(bak, orig) {
+		t.Errorf("backup does not hold the original (%v)", err)
+	}
+	// the input is hashed as it is backed up
+	if sum, _ := HashFile(res.Backup); res.InputSHA256 != sum {
+		t.Errorf("InputSHA256 = %q, want %q", res.InputSHA256, sum)
+	}
+	if _, err := Convert(path, path, "R2022b", Options{Backup: true}); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("second backup: error = %v, want ErrOutputExists", err)
+	}
+	// a backup that already holds the input, as after a failed attempt,
+	// is fine
+	os.WriteFile(path, orig, 0o644)
+	if _, err := Convert(path, path, "R2022b", Options{Backup: true}); err != nil {
+		t.Errorf("backup identical to the input: %v", err)
+	}
+}
+
+func TestConvertRefusesExistingOutput(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.slx")
+	out := filepath.Join(dir, "out.slx")
+	writeFixture(t, in, modelFixture)
+	if err := os.WriteFile(out, []byte("keep me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Convert(in, out, "R2023b", Options{}); err == nil {
+		t.Fatal("expected an error for an existing output")
+	}
+	if data, _ := os.ReadFile(out); string(data) != "keep me" {
+		t.Error("existing output was overwritten")
+	}
+	if _, err := Convert(in, out, "R2023b", Options{Force: true}); err != nil {
+		t.Fatalf("with Force: %v", err)
+	}
+}
+
+func TestConvertRejectsNonArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []fixtureEntry
+		want    string
+	}{
+		{"no metadata", []fixtureEntry{{Name: "simulink/blockdiagram.xml", Body: "<x/>"}}, "no metadata/ folder"},
+		{"no tags", []fixtureEntry{{Name: "metadata/coreProperties.xml", Body: "<cp/>"}}, "no version tags"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "model.slx")
+			writeFixture(t, in, tt.entries)
+			_, err := Convert(in, filepath.Join(dir, "out.slx"), "R2023b", Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "model.slx")
+	writeFixture(t, model, modelFixture)
+	noMeta := filepath.Join(dir, "nometa.slx")
+	writeFixture(t, noMeta, []fixtureEntry{{Name: "simulink/blockdiagram.xml", Body: "<x/>"}})
+	noTags := filepath.Join(dir, "notags.slx")
+	writeFixture(t, noTags, []fixtureEntry{{Name: "metadata/coreProperties.xml", Body: "<cp/>"}})
+	text := filepath.Join(dir, "text.slx")
+	if err := os.WriteFile(text, []byte("not a zip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(dir, "existing.slx")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		in, out string
+		release string
+		opts    Options
+		want    error
+	}{
+		{"bad release", model, "out.slx", "2023b", Options{}, ErrUnsupportedRelease},
+		{"not a zip", text, "out.slx", "R2023b", Options{}, ErrInvalidArchive},
+		{"no metadata folder", noMeta, "out.slx", "R2023b", Options{}, ErrInvalidArchive},
+		{"no tags", noTags, "out.slx", "R2023b", Options{}, ErrNoMetadata},
+		{"output exists", model, existing, "R2023b", Options{}, ErrOutputExists},
+		{"direction", model, "out.slx", "R2025a", Options{DowngradeOnly: true}, ErrWrongDirection},
+		{"missing input", filepath.Join(dir, "missing.slx"), "out.slx", "R2023b", Options{}, fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := tt.out
+			if !filepath.IsAbs(out) {
+				out = filepath.Join(t.TempDir(), out)
+			}
+			_, err := Convert(tt.in, out, tt.release, tt.opts)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want errors.Is %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertReadOnly(t *testing.T) {
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			in := filepath.Join(t.TempDir(), "model.slx")
+			writeFixture(t, in, modelFixture)
+			if err := os.Chmod(in, 0o444); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Convert(in, in, "R2023b", Options{InMemory: mode.inMemory, extract: !mode.inMemory}); !errors.Is(err, ErrReadOnly) {
+				t.Fatalf("error = %v, want ErrReadOnly", err)
+			}
+			res, err := Convert(in, in, "R2023b", Options{InMemory: mode.inMemory, extract: !mode.inMemory, ForceWritable: true, Verify: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Changes) != 3 {
+				t.Errorf("got %d changes, want 3", len(res.Changes))
+			}
+			if info, err := os.Stat(in); err != nil || info.Mode().Perm() != 0o444 {
+				t.Errorf("mode after conversion = %v, %v; want the original 0444", info.Mode().Perm(), err)
+			}
+		})
+	}
+}
+
+func TestConvertSizeLimits(t *testing.T) {
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "model.slx")
+			out := filepath.Join(dir, "out.slx")
+			writeFixture(t, in, modelFixture)
+
+			for _, opts := range []Options{{MaxEntrySize: 100}, {MaxTotalSize: 400}} {
+				opts.InMemory, opts.extract = mode.inMemory, !mode.inMemory
+				if _, err := Convert(in, out, "R2023b", opts); !errors.Is(err, ErrTooLarge) {
+					t.Errorf("%+v: error = %v, want ErrTooLarge", opts, err)
+				}
+				if _, err := os.Stat(out); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("%+v: output written despite the limit", opts)
+				}
+			}
+			if _, err := Convert(in, out, "R2023b", Options{InMemory: mode.inMemory, extract: !mode.inMemory, MaxEntrySize: -1, MaxTotalSize: 400 << 10}); err != nil {
+				t.Errorf("within the limits: %v", err)
+			}
+		})
+	}
+}
+
+func TestSizeBudget(t *testing.T) {
+	// an entry that decompresses to more than it declares is still caught
+	b := newSizeBudget(Options{MaxEntrySize: 10, MaxTotalSize: 15})
+	if _, err := io.ReadAll(b.reader("a", strings.NewReader("0123456789"))); err != nil {
+		t.Fatalf("entry at the limit: %v", err)
+	}
+	if _, err := io.ReadAll(b.reader("b", strings.NewReader("0123456789"))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("over the total: error = %v, want ErrTooLarge", err)
+	}
+	b = newSizeBudget(Options{MaxEntrySize: 10, MaxTotalSize: -1})
+	if _, err := io.ReadAll(b.reader("c", strings.NewReader("0123456789!"))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("over the entry limit: error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestConvertCopiesRaw(t *testing.T) {
+	// deflated at a level the converter does not use, so a recompressed
+	// entry would come out different
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.slx")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestSpeed)
+	})
+	payload := strings.Repeat("<Block BlockType=\"Gain\" Name=\"g\"/>\n", 2000)
+	for _, e := range []fixtureEntry{modelFixture[1], {Name: "simulink/blockdiagram.xml", Body: payload}} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.Name, Method: zip.Deflate, Modified: fixtureTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, e.Body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(in, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := func(path string) (zip.FileHeader, []byte) {
+		t.Helper()
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if f.Name == "simulink/blockdiagram.xml" {
+				rc, _ := f.OpenRaw()
+				data, _ := io.ReadAll(rc)
+				return f.FileHeader, data
+			}
+		}
+		t.Fatalf("%s: no blockdiagram.xml", path)
+		return zip.FileHeader{}, nil
+	}
+	_, orig := raw(in)
+
+	for _, tt := range []struct {
+		name string
+		opts Options
+		same bool
+	}{
+		{"Copy", Options{InMemory: true}, true},
+		{"Level", Options{InMemory: true, CompressionLevel: flate.BestCompression}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out.slx")
+			if _, err := Convert(in, out, "R2023b", tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			h, data := raw(out)
+			if same := bytes.Equal(data, orig); same != tt.same {
+				t.Errorf("compressed bytes unchanged = %v, want %v", same, tt.same)
+			}
+			if !h.Modified.Equal(fixtureTime) {
+				t.Errorf("Modified = %v, want %v", h.Modified, fixtureTime)
+			}
+			headers, contents := readArchive(t, out)
+			checkMATLABCompatible(t, headers)
+			if contents["simulink/blockdiagram.xml"] != payload {
+				t.Error("blockdiagram.xml contents changed")
+			}
+			if !strings.Contains(contents["metadata/mwcoreProperties.xml"], "<release>R2023b</release>") {
+				t.Errorf("metadata not updated:\n%s", contents["metadata/mwcoreProperties.xml"])
+			}
+		})
+	}
+}
+
+func TestConvertSkipsCorruptMetadata(t *testing.T) {
+	entries := append([]fixtureEntry(nil), modelFixture...)
+	entries[1].Body = "<mwcoreProperties><release>R2024b</release"
+
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "model.slx")
+			out := filepath.Join(dir, "out.slx")
+			writeFixture(t, in, entries)
+
+			res, err := Convert(in, out, "R2023b", Options{InMemory: mode.inMemory, extract: !mode.inMemory})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.BadMetadata) != 1 || res.BadMetadata[0].File != "metadata/mwcoreProperties.xml" {
+				t.Errorf("BadMetadata = %v", res.BadMetadata)
+			}
+			_, contents := readArchive(t, out)
+			if contents["metadata/mwcoreProperties.xml"] != entries[1].Body {
+				t.Error("corrupt entry was not copied through unchanged")
+			}
+		})
+	}
+}
+
+func TestConvertDirectionGuards(t *testing.T) {
+	tests := []struct {
+		release string
+		opts    Options
+		wantErr bool
+	}{
+		{"R2023b", Options{DowngradeOnly: true}, false},
+		{"R2025a", Options{DowngradeOnly: true}, true},
+		{"R2025a", Options{UpgradeOnly: true}, false},
+		{"R2023b", Options{UpgradeOnly: true}, true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		in := filepath.Join(dir, "model.slx")
+		writeFixture(t, in, modelFixture)
+		_, err := Convert(in, filepath.Join(dir, "out.slx"), tt.release, tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("R2024b -> %s with %+v: err = %v, wantErr %v", tt.release, tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConvertStream(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.slx")
+	writeFixture(t, in, modelFixture)
+	data, err := os.ReadFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	res, err := ConvertStream(bytes.NewReader(data), &out, "R2023b", Options{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.InputSHA256 == "" || res.OutputSHA256 == "" {
+		t.Error("checksums not recorded")
+	}
+
+	outPath := filepath.Join(dir, "out.slx")
+	if err := os.WriteFile(outPath, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	headers, contents := readArchive(t, outPath)
+	checkMATLABCompatible(t, headers)
+	for _, name := range []string{"metadata/coreProperties.xml", "metadata/mwcoreProperties.xml"} {
+		checkGolden(t, "R2023b/"+name, contents[name])
+	}
+}
+
+func TestConvertGzip(t *testing.T) {
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			dir := t.TempDir()
+			plain := filepath.Join(dir, "plain.slx")
+			writeFixture(t, plain, modelFixture)
+			data, err := os.ReadFile(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := filepath.Join(dir, "model.slx.gz")
+			var gz bytes.Buffer
+			gw := gzip.NewWriter(&gz)
+			gw.Write(data)
+			gw.Close()
+			if err := os.WriteFile(in, gz.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if found, err := Detect(in); err != nil || PrimaryRelease(found) != "R2024b" {
+				t.Fatalf("Detect = %v, %v; want R2024b", found, err)
+			}
+			opts := Options{InMemory: mode.inMemory, extract: !mode.inMemory, Verify: true, Checksum: true}
+			res, err := Convert(in, in, "R2023b", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Changes) != 3 {
+				t.Errorf("got %d changes, want 3", len(res.Changes))
+			}
+			if sum, _ := HashFile(in); res.OutputSHA256 != sum {
+				t.Errorf("OutputSHA256 = %s, want the digest of the gzipped file %s", res.OutputSHA256, sum)
+			}
+
+			f, err := os.Open(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			gr, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatalf("output is not gzipped: %v", err)
+			}
+			unzipped := filepath.Join(dir, "out.slx")
+			data, err = io.ReadAll(gr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(unzipped, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			headers, contents := readArchive(t, unzipped)
+			checkMATLABCompatible(t, headers)
+			for _, name := range []string{"metadata/coreProperties.xml", "metadata/mwcoreProperties.xml"} {
+				checkGolden(t, "R2023b/"+name, contents[name])
+			}
+
+			if res, err := Convert(in, in, "R2023b", opts); err != nil || !res.Skipped {
+				t.Errorf("second conversion: skipped = %v, %v; want skipped", res.Skipped, err)
+			}
+		})
+	}
+}
+
+func TestConvertNestedArchive(t *testing.T) {
+	var nested []fixtureEntry
+	for _, e := range modelFixture {
+		e.Name = "wrapped/" + e.Name
+		nested = append(nested, e)
+	}
+
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "model.slx")
+			out := filepath.Join(dir, "out.slx")
+			writeFixture(t, in, nested)
+
+			res, err := Convert(in, out, "R2023b", Options{InMemory: mode.inMemory, extract: !mode.inMemory, Verify: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"wrapped/metadata/mwcoreProperties.xml", "wrapped/metadata/coreProperties.xml"}
+			if strings.Join(res.MetadataEntries, ",") != strings.Join(want, ",") {
+				t.Errorf("MetadataEntries = %v, want %v", res.MetadataEntries, want)
+			}
+			if len(res.Changes) != 3 {
+				t.Errorf("got %d changes, want 3", len(res.Changes))
+			}
+		})
+	}
+}
+
+func TestConvertSkipTags(t *testing.T) {
+	tests := []struct {
+		skip []string
+		want int
+	}{
+		{[]string{"coreProperties.xml:version"}, 2},
+		{[]string{"metadata/mwcoreProperties.xml"}, 1},
+		{[]string{"coreProperties.xml:release"}, 3}, // no such tag there
+	}
+	for _, mode := range convertModes {
+		for _, tt := range tests {
+			t.Run(mode.name+"/"+strings.Join(tt.skip, ","), func(t *testing.T) {
+				dir := t.TempDir()
+				in := filepath.Join(dir, "model.slx")
+				out := filepath.Join(dir, "out.slx")
+				writeFixture(t, in, modelFixture)
+
+				res, err := Convert(in, out, "R2023b", Options{InMemory: mode.inMemory, extract: !mode.inMemory, SkipTags: tt.skip, Verify: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(res.Changes) != tt.want {
+					t.Errorf("got %d changes, want %d: %v", len(res.Changes), tt.want, res.Changes)
+				}
+				for _, c := range res.Changes {
+					if (Options{SkipTags: tt.skip}).skipsTag(c.File, c.Tag) {
+						t.Errorf("skipped <%s> in %s was rewritten", c.Tag, c.File)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestConvertTextTags(t *testing.T) {
+	entries := append([]fixtureEntry(nil), modelFixture...)
+	entries[1].Body = `<mwcoreProperties><release>R2024b</release><matlabRelease>R2024b</matlabRelease><description>Saved with MATLAB R2024b (24.2)</description></mwcoreProperties>`
+
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			in := filepath.Join(t.TempDir(), "model.slx")
+			writeFixture(t, in, entries)
+
+			opts := Options{InMemory: mode.inMemory, extract: !mode.inMemory, TextTags: []string{"description"}, Verify: true}
+			res, err := Convert(in, in, "R2023b", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := TagChange{File: "metadata/mwcoreProperties.xml", Tag: "description", Element: "description", Old: "Saved with MATLAB R2024b (24.2)", New: "Saved with MATLAB R2023b (24.2)"}
+			found := false
+			for _, c := range res.Changes {
+				found = found || c == want
+			}
+			if !found {
+				t.Errorf("changes = %+v, want one of them %+v", res.Changes, want)
+			}
+			if res, err := Convert(in, in, "R2023b", opts); err != nil || !res.Skipped {
+				t.Errorf("second conversion: skipped = %v, %v; want skipped", res.Skipped, err)
+			}
+		})
+	}
+}
+
+func TestConvertNonASCIINames(t *testing.T) {
+	entries := append(append([]fixtureEntry(nil), modelFixture...),
+		fixtureEntry{Name: "resources/画像.png", Method: zip.Store, Body: "png"})
+
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "model.slx")
+			out := filepath.Join(dir, "out.slx")
+			writeFixture(t, in, entries)
+
+			res, err := Convert(in, out, "R2023b", Options{InMemory: mode.inMemory, extract: !mode.inMemory})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"resources/画像.png"}; strings.Join(res.NonASCIINames, ",") != strings.Join(want, ",") {
+				t.Errorf("NonASCIINames = %v, want %v", res.NonASCIINames, want)
+			}
+			headers, _ := readArchive(t, out)
+			checkMATLABCompatible(t, headers)
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	entries := append([]fixtureEntry(nil), modelFixture...)
+	// unreadable metadata does not stop a normalization
+	entries[0].Body = "<cp:coreProperties"
+
+	for _, mode := range convertModes {
+		t.Run(mode.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "model.slx")
+			out := filepath.Join(dir, "out.slx")
+			writeFixture(t, in, entries)
+
+			res, err := Normalize(in, out, Options{InMemory: mode.inMemory, extract: !mode.inMemory, Verify: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Changes) != 0 || len(res.BadMetadata) != 0 {
+				t.Errorf("Normalize changed metadata: %v, %v", res.Changes, res.BadMetadata)
+			}
+			headers, got := readArchive(t, out)
+			checkMATLABCompatible(t, headers)
+			for _, e := range entries {
+				name := strings.ReplaceAll(e.Name, `\`, "/")
+				if got[name] != e.Body {
+					t.Errorf("%s = %q, want %q unchanged", name, got[name], e.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.slx")
+	writeFixture(t, in, modelFixture)
+
+	// storing the entries means extracting and zipping them again
+	work := t.TempDir()
+	if _, err := Convert(in, filepath.Join(dir, "out.slx"), "R2023b", Options{WorkDir: work, Store: true}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(work); len(entries) != 0 {
+		t.Errorf("work dir not cleaned up: %v", entries)
+	}
+
+	// the extraction really happens there: a missing work dir fails
+	missing := filepath.Join(work, "missing")
+	if _, err := Convert(in, filepath.Join(dir, "out2.slx"), "R2023b", Options{WorkDir: missing, Store: true}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error = %v, want fs.ErrNotExist for a missing work dir", err)
+	}
+	// while entries copied as they are need no work dir at all
+	if _, err := Convert(in, filepath.Join(dir, "out3.slx"), "R2023b", Options{WorkDir: missing}); err != nil {
+		t.Errorf("error = %v copying entries as they are, want none", err)
+	}
+}
+
+func TestIsWorkDirName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"model_unzipped":        true,
+		"model_unzipped1234567": true,
+		"slxconvert_batch98765": true,
+		"slxconvert_selftest1":  true,
+		"model_unzipped_notes":  false,
+		"unzipped_models":       false,
+		"my_slxconvert_batch12": false,
+		"models":                false,
+	} {
+		if got := IsWorkDirName(name); got != want {
+			t.Errorf("IsWorkDirName(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	// and the names actually generated match
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.slx")
+	writeFixture(t, in, modelFixture)
+	res, err := Convert(in, filepath.Join(dir, "out.slx"), "R2023b", Options{WorkDir: t.TempDir(), KeepWorkDir: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsWorkDirName(filepath.Base(res.WorkDir)) {
+		t.Errorf("IsWorkDirName(%q) = false for a generated work dir", filepath.Base(res.WorkDir))
+	}
+}
+
+func TestConvertKeepWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.slx")
+	writeFixture(t, in, modelFixture)
+
+	res, err := Convert(in, filepath.Join(dir, "out.slx"), "R2023b", Options{WorkDir: t.TempDir(), KeepWorkDir: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.WorkDir == "" {
+		t.Fatal("Result.WorkDir not set")
+	}
+	data, err := os.ReadFile(filepath.Join(res.WorkDir, "metadata", "mwcoreProperties.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<release>R2023b</release>") {
+		t.Errorf("kept metadata not rewritten: %s", data)
+	}
+}
+
+func TestConvertPreservesExtraFields(t *testing.T) {
+	// a custom extra field (ID 0xcafe) and comments stamped by other tools
+	custom := []byte{0xfe, 0xca, 3, 0, 'a', 'b', 'c'}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range modelFixture {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.Name, Method: e.Method, Modified: fixtureTime, Extra: custom, Comment: "entry note"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, e.Body)
+	}
+	zw.SetComment("stamped by build 42")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.slx")
+	if err := os.WriteFile(in, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range convertModes {
+		for _, strip := range []bool{false, true} {
+			out := filepath.Join(t.TempDir(), "out.slx")
+			opts := Options{InMemory: mode.inMemory, extract: !mode.inMemory, StripExtra: strip}
+			if _, err := Convert(in, out, "R2023b", opts); err != nil {
+				t.Fatal(err)
+			}
+			r, err := zip.OpenReader(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantComment, wantEntry := "stamped by build 42", "entry note"
+			if strip {
+				wantComment, wantEntry = "", ""
+			}
+			if r.Comment != wantComment {
+				t.Errorf("%s strip=%v: archive comment %q, want %q", mode.name, strip, r.Comment, wantComment)
+			}
+			for _, f := range r.File {
+				if has := bytes.Contains(f.Extra, custom); has == strip {
+					t.Errorf("%s strip=%v: %s has custom extra field = %v", mode.name, strip, f.Name, has)
+				}
+				if f.Comment != wantEntry {
+					t.Errorf("%s strip=%v: %s comment %q, want %q", mode.name, strip, f.Name, f.Comment, wantEntry)
+				}
+			}
+			r.Close()
+		}
+	}
+}
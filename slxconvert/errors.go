@@ -0,0 +1,48 @@
+package slxconvert
+
+import "errors"
+
+// Errors returned, usually wrapped with more detail, by the conversion
+// functions. Use errors.Is to test for them; failures of the file system
+// itself are passed through, so errors.Is(err, fs.ErrPermission) and the
+// like work too.
+var (
+	// ErrInvalidArchive means the input is not a zip archive, or not one
+	// laid out like an SLX/SLDD package.
+	ErrInvalidArchive = errors.New("not a valid SLX/SLDD archive")
+
+	// ErrNoMetadata means the archive has a metadata folder but none of
+	// the version tags could be found in it.
+	ErrNoMetadata = errors.New("no version tags found")
+
+	// ErrUnsupportedRelease means a release string is not of the form
+	// R20YY[a|b].
+	ErrUnsupportedRelease = errors.New("unsupported release")
+
+	// ErrOutputExists means the output or backup file exists and
+	// Options.Force was not set.
+	ErrOutputExists = errors.New("already exists (use --force to overwrite)")
+
+	// ErrReadOnly means the output file exists without write permission
+	// and Options.ForceWritable was not set.
+	ErrReadOnly = errors.New("is read-only (use --force-writable to convert it anyway)")
+
+	// ErrWrongDirection means Options.DowngradeOnly or UpgradeOnly ruled
+	// the conversion out.
+	ErrWrongDirection = errors.New("conversion direction not allowed")
+
+	// ErrMetadataTooLarge means a metadata entry exceeds MaxMetadataSize.
+	ErrMetadataTooLarge = errors.New("metadata entry too large")
+
+	// ErrTooLarge means an archive entry, or all of them together, would
+	// decompress to more than Options.MaxEntrySize or MaxTotalSize.
+	ErrTooLarge = errors.New("archive too large to extract")
+
+	// ErrLocked is returned by LockFile when another process holds the
+	// lock.
+	ErrLocked = errors.New("locked by another process")
+
+	// ErrVerifyFailed means Verify found a version tag that does not read
+	// the target release.
+	ErrVerifyFailed = errors.New("verification failed")
+)
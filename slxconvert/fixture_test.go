@@ -0,0 +1,130 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// fixtureEntry is one file of a test archive.
+type fixtureEntry struct {
+	Name   string
+	Body   string
+	Method uint16
+}
+
+// modelFixture is a minimal SLX: the release lives in both metadata files,
+// one entry is stored uncompressed and one was written with Windows path
+// separators, as some third-party tools do.
+var modelFixture = []fixtureEntry{
+	{Name: "metadata/coreProperties.xml", Method: zip.Deflate, Body: `<?xml version="1.0" encoding="UTF-8"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties"><cp:version>R2024b</cp:version><cp:category>model</cp:category></cp:coreProperties>`},
+	{Name: "metadata/mwcoreProperties.xml", Method: zip.Deflate, Body: `<?xml version="1.0" encoding="UTF-8"?>
+<mwcoreProperties><release>R2024b</release><matlabRelease>R2024b</matlabRelease></mwcoreProperties>`},
+	{Name: "simulink/blockdiagram.xml", Method: zip.Deflate, Body: `<ModelInformation><Model Name="demo"/></ModelInformation>`},
+	{Name: "simulink/graphicalInterface.xml", Method: zip.Store, Body: `<GraphicalInterface/>`},
+	{Name: `simulink\windows.xml`, Method: zip.Deflate, Body: `<Windows/>`},
+}
+
+// fixtureTime is the modification time of every fixture entry.
+var fixtureTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// writeFixture builds an archive of entries at path. The UTF-8 flag is set
+// on every entry so tests can tell that the converter clears it.
+func writeFixture(t testing.TB, path string, entries []fixtureEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     e.Name,
+			Method:   e.Method,
+			Modified: fixtureTime,
+			Flags:    1 << 11,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, e.Body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readArchive returns the headers and contents of every entry in path.
+func readArchive(t testing.TB, path string) ([]zip.FileHeader, map[string]string) {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var headers []zip.FileHeader
+	contents := make(map[string]string)
+	for _, f := range r.File {
+		headers = append(headers, f.FileHeader)
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[f.Name] = string(data)
+	}
+	return headers, contents
+}
+
+// checkMATLABCompatible fails t for any entry that MATLAB would reject or
+// misread: the UTF-8 flag set, backslash separators or an unexpected method.
+func checkMATLABCompatible(t *testing.T, headers []zip.FileHeader) {
+	t.Helper()
+	for _, h := range headers {
+		if h.Flags&(1<<11) != 0 {
+			t.Errorf("%s: UTF-8 flag is set", h.Name)
+		}
+		if bytes.ContainsRune([]byte(h.Name), '\\') {
+			t.Errorf("%s: name contains a backslash", h.Name)
+		}
+		if h.Method != zip.Deflate && h.Method != zip.Store {
+			t.Errorf("%s: method %d, want Deflate or Store", h.Name, h.Method)
+		}
+	}
+}
+
+// checkGolden compares got with testdata/name, rewriting the file instead
+// when -update is given.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", filepath.FromSlash(name))
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s mismatch\n got: %s\nwant: %s", name, got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// GzipExt is the extension of a gzip-compressed archive, e.g.
+// model.slx.gz. Such archives are decompressed for conversion and the
+// output is compressed again if its name ends in GzipExt too.
+const GzipExt = ".gz"
+
+// IsGzip reports whether path names a gzip-compressed archive.
+func IsGzip(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), GzipExt)
+}
+
+// Unwrapped returns path without a trailing GzipExt, so that the extension
+// of the archive inside, e.g. ".slx", decides how it is treated.
+func Unwrapped(path string) string {
+	if IsGzip(path) {
+		return path[:len(path)-len(GzipExt)]
+	}
+	return path
+}
+
+// OpenInput opens the archive at path for reading, decompressing it first
+// if it is gzipped. The returned function releases it.
+func OpenInput(path string) (*zip.Reader, func(), error) {
+	return openInput(context.Background(), path, Options{})
+}
+
+// openInput opens the archive at path, decompressing it first if it is
+// gzipped (see openGzip). The returned close function may be called more
+// than once.
+func openInput(ctx context.Context, path string, opts Options) (*zip.Reader, func(), error) {
+	if IsGzip(path) {
+		return openGzip(ctx, path, opts)
+	}
+	r, err := openArchive(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &r.Reader, sync.OnceFunc(func() { r.Close() }), nil
+}
+
+// openGzip decompresses the gzipped archive at path. With opts.InMemory
+// the archive is held in memory, otherwise it is spooled to a temporary
+// file under opts.WorkDir.
+func openGzip(ctx context.Context, path string, opts Options) (*zip.Reader, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer gr.Close()
+	// the archive inside is no bigger than its contents, so the total
+	// size limit bounds the decompressed stream as well
+	var src io.Reader = gr
+	if _, total := opts.sizeLimits(); total >= 0 {
+		src = &budgetReader{budget: &sizeBudget{entryMax: total, totalMax: -1}, name: filepath.Base(path), r: gr}
+	}
+
+	if opts.InMemory {
+		data, err := io.ReadAll(ctxReader{ctx, src})
+		if err == nil {
+			var zr *zip.Reader
+			if zr, err = zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+				return zr, func() {}, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	zr, cleanup, err := spoolInput(ctx, src, opts.WorkDir, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	return zr, sync.OnceFunc(cleanup), nil
+}
+
+// convertGzip is convertInMemory for an input or output that is gzipped:
+// the archive is rewritten entry by entry, straight into a gzip stream if
+// outSLX ends in GzipExt.
+func convertGzip(ctx context.Context, slx, outSLX, release string, opts Options) ([]TagChange, []MetadataError, error) {
+	zr, closeInput, err := openInput(ctx, slx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeInput()
+	files := MetadataFilesFor(filepath.Ext(typedPath(slx, outSLX)))
+
+	if opts.DryRun {
+		return rewriteArchive(ctx, zr, io.Discard, files, release, opts)
+	}
+
+	var changes []TagChange
+	var bad []MetadataError
+	err = WriteAtomic(outSLX, func(w io.Writer) error {
+		defer closeInput()
+		if !IsGzip(outSLX) {
+			var err error
+			changes, bad, err = rewriteArchive(ctx, zr, w, files, release, opts)
+			return err
+		}
+		// the checksum is of the file written, not the archive inside it
+		if opts.outputHash != nil {
+			w = io.MultiWriter(w, opts.outputHash)
+			opts.outputHash = nil
+		}
+		gw := gzip.NewWriter(w)
+		gw.Name = filepath.Base(Unwrapped(outSLX))
+		var err error
+		changes, bad, err = rewriteArchive(ctx, zr, gw, files, release, opts)
+		if err != nil {
+			return err
+		}
+		return gw.Close()
+	})
+	if err != nil {
+		return nil, bad, err
+	}
+	return changes, bad, nil
+}
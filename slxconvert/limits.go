@@ -0,0 +1,88 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+)
+
+// Default size limits, generous for real models (the largest seen in the
+// wild are a few hundred MB) but small enough to stop a zip bomb before it
+// fills the disk or memory. See Options.MaxEntrySize and MaxTotalSize.
+const (
+	DefaultMaxEntrySize int64 = 2 << 30
+	DefaultMaxTotalSize int64 = 8 << 30
+)
+
+// sizeLimits returns the entry and total limits opts asks for, with zero
+// meaning the default and a negative value no limit at all.
+func (o Options) sizeLimits() (entry, total int64) {
+	limit := func(v, def int64) int64 {
+		switch {
+		case v == 0:
+			return def
+		case v < 0:
+			return -1
+		}
+		return v
+	}
+	return limit(o.MaxEntrySize, DefaultMaxEntrySize), limit(o.MaxTotalSize, DefaultMaxTotalSize)
+}
+
+// checkSizes fails with ErrTooLarge if the uncompressed sizes r declares
+// for its entries exceed the limits of opts.
+func checkSizes(r *zip.Reader, opts Options) error {
+	entryMax, totalMax := opts.sizeLimits()
+	var total uint64
+	for _, f := range r.File {
+		if entryMax >= 0 && f.UncompressedSize64 > uint64(entryMax) {
+			return fmt.Errorf("%w: %s is %d bytes uncompressed (limit %d)", ErrTooLarge, f.Name, f.UncompressedSize64, entryMax)
+		}
+		total += f.UncompressedSize64
+		if totalMax >= 0 && total > uint64(totalMax) {
+			return fmt.Errorf("%w: entries add up to over %d bytes uncompressed", ErrTooLarge, totalMax)
+		}
+	}
+	return nil
+}
+
+// sizeBudget holds the limits of a single archive while its entries are
+// copied, so that an entry that decompresses to more than it declared is
+// caught too.
+type sizeBudget struct {
+	entryMax, totalMax int64 // negative for no limit
+	total              int64 // bytes read so far
+}
+
+func newSizeBudget(opts Options) *sizeBudget {
+	b := new(sizeBudget)
+	b.entryMax, b.totalMax = opts.sizeLimits()
+	return b
+}
+
+// reader returns r, the contents of entry name, failing with ErrTooLarge
+// once it goes over either limit.
+func (b *sizeBudget) reader(name string, r io.Reader) io.Reader {
+	return &budgetReader{budget: b, name: name, r: r}
+}
+
+type budgetReader struct {
+	budget *sizeBudget
+	name   string
+	r      io.Reader
+	n      int64 // bytes of the entry read so far
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	b := br.budget
+	br.n += int64(n)
+	b.total += int64(n)
+	switch {
+	case b.entryMax >= 0 && br.n > b.entryMax:
+		return n, fmt.Errorf("%w: %s is over %d bytes uncompressed", ErrTooLarge, br.name, b.entryMax)
+	case b.totalMax >= 0 && b.total > b.totalMax:
+		return n, fmt.Errorf("%w: entries add up to over %d bytes uncompressed", ErrTooLarge, b.totalMax)
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package slxconvert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockPath returns the lock file LockFile uses for path. It is a dotfile
+// next to path, so directory runs pass over it.
+func LockPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+}
+
+// LockFile takes an advisory lock on path by creating LockPath(path)
+// exclusively, and returns a function that releases it. If the lock is
+// already held it returns ErrLocked, unless the lock file is older than
+// staleAfter, in which case it is assumed to be left over from a crashed
+// run and is broken. A staleAfter of 0 never breaks a lock.
+func LockFile(path string, staleAfter time.Duration) (func(), error) {
+	lock := LockPath(path)
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			host, _ := os.Hostname()
+			fmt.Fprintf(f, "%d@%s\n", os.Getpid(), host)
+			f.Close()
+			// removed like a work directory should the run be interrupted
+			trackWorkDir(lock)
+			return func() { removeWorkDir(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		info, err := os.Stat(lock)
+		if os.IsNotExist(err) && attempt == 0 {
+			continue // released in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attempt > 0 || staleAfter <= 0 || time.Since(info.ModTime()) < staleAfter {
+			return nil, fmt.Errorf("%s: %w (%s)", path, ErrLocked, lock)
+		}
+		if err := os.Remove(lock); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package slxconvert
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.slx")
+
+	unlock, err := LockFile(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockFile(path, time.Hour); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second lock: got %v, want ErrLocked", err)
+	}
+	unlock()
+	if _, err := os.Stat(LockPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("lock file left after unlock: %v", err)
+	}
+
+	unlock, err = LockFile(path, time.Hour)
+	if err != nil {
+		t.Fatalf("relock: %v", err)
+	}
+	defer unlock()
+
+	// a lock older than staleAfter is from a crashed run and is broken
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(LockPath(path), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockFile(path, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("staleAfter 0: got %v, want ErrLocked", err)
+	}
+	if _, err := LockFile(path, time.Hour); err != nil {
+		t.Fatalf("stale lock not broken: %v", err)
+	}
+}
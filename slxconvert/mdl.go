@@ -0,0 +1,174 @@
+package slxconvert
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SimulinkVersions maps releases to the Simulink version number that a
+// text-format .mdl model records on its Version line.
+var SimulinkVersions = map[string]string{
+	"R2020a": "10.1",
+	"R2020b": "10.2",
+	"R2021a": "10.3",
+	"R2021b": "10.4",
+	"R2022a": "10.5",
+	"R2022b": "10.6",
+	"R2023a": "10.7",
+	"R2023b": "23.2",
+	"R2024a": "24.1",
+	"R2024b": "24.2",
+	"R2025a": "25.1",
+	"R2025b": "25.2",
+}
+
+// MDLVersionKey is the key under which DetectMDL reports the release
+// implied by a model's Version line.
+const MDLVersionKey = "Version"
+
+// mdlVersionLine matches the unquoted "Version  23.2" line of a Model
+// block. Quoted Version parameters of configuration objects are left
+// alone.
+var mdlVersionLine = regexp.MustCompile(`(?m)^([ \t]*Version[ \t]+)(\d+\.\d+)([ \t]*\r?)$`)
+
+// IsMDL reports whether path names a text-format .mdl model, which is
+// rewritten in place of its text rather than as an archive.
+func IsMDL(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".mdl")
+}
+
+// mdlTagPattern matches the <tag>value</tag> elements named in tags,
+// with or without a namespace prefix, as found in the metadata parts that
+// newer releases embed in an .mdl file. Attribute tags are not supported.
+func mdlTagPattern(tags []string) *regexp.Regexp {
+	var names []string
+	for _, tag := range tags {
+		if _, attr := splitTag(tag); attr == "" {
+			names = append(names, regexp.QuoteMeta(tag))
+		}
+	}
+	name := `((?:[\w.-]+:)?(?:` + strings.Join(names, "|") + `))`
+	return regexp.MustCompile(`<` + name + `>([^<]*)</` + name + `>`)
+}
+
+// localName strips any namespace prefix from an element name.
+func localName(name string) string {
+	if _, local, ok := strings.Cut(name, ":"); ok {
+		return local
+	}
+	return name
+}
+
+// releaseForVersion returns the release recorded as Simulink version v,
+// or "" if it is not in SimulinkVersions.
+func releaseForVersion(v string) string {
+	for rel, ver := range SimulinkVersions {
+		if ver == v {
+			return rel
+		}
+	}
+	return ""
+}
+
+// DetectMDL reads the release of the .mdl model at path: the first value
+// of each of tags found in its embedded metadata, and under MDLVersionKey
+// the release matching its Version line.
+func DetectMDL(path string, tags []string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]string)
+	for _, m := range mdlTagPattern(tags).FindAllStringSubmatch(string(data), -1) {
+		if m[1] != m[3] {
+			continue
+		}
+		if tag := localName(m[1]); found[tag] == "" {
+			found[tag] = m[2]
+		}
+	}
+	if m := mdlVersionLine.FindStringSubmatch(string(data)); m != nil {
+		if rel := releaseForVersion(m[2]); rel != "" {
+			found[MDLVersionKey] = rel
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w in %s: no Version line or metadata", ErrNoMetadata, path)
+	}
+	return found, nil
+}
+
+// rewriteMDL returns data with the model's Version line and every element
+// named in tags set for release, along with the changes made. A file name
+// of name is recorded in each change.
+func rewriteMDL(data []byte, name, release string, tags []string) ([]byte, []TagChange, error) {
+	text := string(data)
+	var changes []TagChange
+
+	if loc := mdlVersionLine.FindStringSubmatchIndex(text); loc != nil {
+		old := text[loc[4]:loc[5]]
+		ver, ok := SimulinkVersions[release]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w %q: no Simulink version number known for the Version line of %s", ErrUnsupportedRelease, release, name)
+		}
+		if old != ver {
+			changes = append(changes, TagChange{File: name, Tag: MDLVersionKey, Old: old, New: ver})
+			text = text[:loc[4]] + ver + text[loc[5]:]
+		}
+	}
+
+	pattern := mdlTagPattern(tags)
+	text = pattern.ReplaceAllStringFunc(text, func(el string) string {
+		m := pattern.FindStringSubmatch(el)
+		if m[1] != m[3] || m[2] == release {
+			return el
+		}
+		changes = append(changes, TagChange{File: name, Tag: localName(m[1]), Element: m[1], Old: m[2], New: release})
+		return "<" + m[1] + ">" + release + "</" + m[1] + ">"
+	})
+	return []byte(text), changes, nil
+}
+
+// convertMDL rewrites the .mdl model slx for release into outSLX. The
+// output is written with WriteAtomic, so outSLX may be slx itself.
+func convertMDL(slx, outSLX, release string, opts Options) ([]TagChange, error) {
+	data, err := os.ReadFile(slx)
+	if err != nil {
+		return nil, err
+	}
+	out, changes, err := rewriteMDL(data, filepath.Base(slx), release, opts.tagsFor(filepath.Base(slx)))
+	if err != nil || opts.DryRun {
+		return changes, err
+	}
+	for _, c := range changes {
+		opts.logf("%s: <%s> %q -> %q", c.File, c.Tag, c.Old, c.New)
+	}
+	return changes, WriteAtomic(outSLX, func(w io.Writer) error {
+		if opts.outputHash != nil {
+			w = io.MultiWriter(w, opts.outputHash)
+		}
+		_, err := w.Write(out)
+		return err
+	})
+}
+
+// verifyMDL is Verify for a .mdl model.
+func verifyMDL(slx, release string, opts Options) error {
+	data, err := os.ReadFile(slx)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", slx, err)
+	}
+	_, changes, err := rewriteMDL(data, filepath.Base(slx), release, opts.tagsFor(filepath.Base(slx)))
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", slx, err)
+	}
+	if len(changes) > 0 {
+		c := changes[0]
+		return fmt.Errorf("verify %s: %w: %s is %q, want %q", slx, ErrVerifyFailed, c.Tag, c.Old, c.New)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package slxconvert
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertMDL(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "mdl", "model.mdl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.mdl")
+	if err := os.WriteFile(in, src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := Detect(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := PrimaryRelease(found); got != "R2024b" || found[MDLVersionKey] != "R2024b" {
+		t.Fatalf("Detect = %v, want R2024b", found)
+	}
+
+	res, err := Convert(in, in, "R2023b", Options{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Changes) != 3 {
+		t.Errorf("got %d changes, want 3 (Version, version, release): %+v", len(res.Changes), res.Changes)
+	}
+	if res.OriginalRelease != "R2024b" {
+		t.Errorf("OriginalRelease = %q, want R2024b", res.OriginalRelease)
+	}
+	out, err := os.ReadFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "mdl/model_R2023b.mdl", string(out))
+	if !strings.Contains(string(out), `Version		      "24.1.0"`) {
+		t.Error("quoted Version parameter of a configuration object was rewritten")
+	}
+
+	// already at the target, so a second run leaves the file alone
+	res, err = Convert(in, in, "R2023b", Options{})
+	if err != nil || !res.Skipped {
+		t.Errorf("second conversion: skipped=%v, err=%v; want skipped", res.Skipped, err)
+	}
+
+	if _, err := Convert(in, filepath.Join(dir, "old.mdl"), "R2019a", Options{}); err == nil {
+		t.Error("expected an error for a release with no known Simulink version")
+	}
+}
+
+func TestConvertTypeFromOutput(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "mdl", "model.mdl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model")
+	if err := os.WriteFile(in, src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// with no extension to go by, the output's names the type
+	res, err := Convert(in, filepath.Join(dir, "model.mdl"), "R2023b", Options{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Changes) != 3 {
+		t.Errorf("got %d changes, want 3", len(res.Changes))
+	}
+}
@@ -0,0 +1,449 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/beevik/etree"
+)
+
+// VersionTags are the metadata elements that carry the release string.
+var VersionTags = []string{"version", "release", "matlabRelease"}
+
+// MetadataFiles are the archive entries that may contain VersionTags.
+var MetadataFiles = []string{
+	"metadata/mwcoreProperties.xml",
+	"metadata/mwcorePropertiesReleaseInfo.xml",
+	"metadata/coreProperties.xml",
+}
+
+// MetadataFilesByType overrides MetadataFiles for archives with a given
+// lower-case extension, e.g. ".mldatx", should a type keep its release
+// elsewhere. Types not listed use MetadataFiles.
+var MetadataFilesByType = map[string][]string{}
+
+// MetadataFilesFor returns the metadata entries to inspect for an archive
+// with extension ext.
+func MetadataFilesFor(ext string) []string {
+	if files, ok := MetadataFilesByType[strings.ToLower(ext)]; ok {
+		return files
+	}
+	return MetadataFiles
+}
+
+// MetadataPaths returns the metadata files, as slash-separated paths
+// relative to dir, that are present in an archive of type ext extracted
+// to dir. Files are matched by name anywhere in the tree, so archives that
+// were re-zipped with an extra top-level folder are still handled.
+func MetadataPaths(dir, ext string) ([]string, error) {
+	files := MetadataFilesFor(ext)
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); isMetadataFile(rel, files) {
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortMetadata(paths, files)
+	return paths, nil
+}
+
+// metadataEntries returns the names of the entries of r that are metadata
+// files, wherever they sit in the archive, in the order of files.
+func metadataEntries(r *zip.Reader, files []string) []string {
+	var names []string
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() && isMetadataFile(f.Name, files) {
+			names = append(names, f.Name)
+		}
+	}
+	sortMetadata(names, files)
+	return names
+}
+
+// sortMetadata orders paths by the position of the metadata file they
+// match in files, then by path.
+func sortMetadata(paths, files []string) {
+	rank := func(p string) int {
+		for i, f := range files {
+			if p == f || strings.HasSuffix(p, "/"+f) {
+				return i
+			}
+		}
+		return len(files)
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		if ri, rj := rank(paths[i]), rank(paths[j]); ri != rj {
+			return ri < rj
+		}
+		return paths[i] < paths[j]
+	})
+}
+
+// TagChange records a single element whose text was (or would be) rewritten.
+// Tag is the tag as asked for, e.g. "version", and Element the name of
+// the element as it appears in the file, namespace prefix and all, e.g.
+// "cp:version".
+type TagChange struct {
+	File    string `json:"file"`
+	Tag     string `json:"tag"`
+	Element string `json:"element,omitempty"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+// Qualified returns the tag of c with the element named as in the file,
+// e.g. "cp:version" or "x:q@release".
+func (c TagChange) Qualified() string {
+	if c.Element == "" {
+		return c.Tag
+	}
+	if _, attr := splitTag(c.Tag); attr != "" {
+		return c.Element + "@" + attr
+	}
+	return c.Element
+}
+
+// MetadataError reports a metadata file that could not be parsed.
+type MetadataError struct {
+	File string
+	Err  error
+}
+
+func (e MetadataError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e MetadataError) Unwrap() error {
+	return e.Err
+}
+
+// UpdateVersions rewrites the elements named in updates within the XML file
+// at xmlPath; those in opts.TextTags only have the release strings in
+// their text replaced. The file is only written if something changed and
+// opts.DryRun is false.
+func UpdateVersions(xmlPath string, updates map[string]string, opts Options) ([]TagChange, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	f, err := os.Open(xmlPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buf.ReadFrom(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	changes := updateDocument(doc, updates, opts)
+	if len(changes) == 0 || opts.DryRun {
+		return changes, nil
+	}
+	// the document holds no references into buf, so it can be reused
+	buf.Reset()
+	if _, err := doc.WriteTo(buf); err != nil {
+		return changes, err
+	}
+	return changes, os.WriteFile(xmlPath, buf.Bytes(), 0o644)
+}
+
+// xmlBuffers recycles the buffers metadata files are read into and
+// written from while being rewritten.
+var xmlBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledBuffer is the largest buffer put back into xmlBuffers, so that
+// one huge metadata file does not pin its memory for the rest of a run.
+const maxPooledBuffer = 1 << 20
+
+func getBuffer() *bytes.Buffer {
+	buf := xmlBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		xmlBuffers.Put(buf)
+	}
+}
+
+// updateDocument rewrites the text of every element named in updates and
+// returns the elements that changed.
+func updateDocument(doc *etree.Document, updates map[string]string, opts Options) []TagChange {
+	// visit tags in a stable order so reports are reproducible
+	tags := make([]string, 0, len(updates))
+	for tag := range updates {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var changes []TagChange
+	for _, tag := range tags {
+		for _, el := range findTag(doc, tag) {
+			old := tagValue(el, tag)
+			opts.logf("match <%s> %q", tag, old)
+			if val := opts.newValue(tag, old, updates[tag]); old != val {
+				changes = append(changes, TagChange{Tag: tag, Element: el.FullTag(), Old: old, New: val})
+				setTagValue(el, tag, val)
+			}
+		}
+	}
+	return changes
+}
+
+// splitTag splits a tag of the form "element@attr", which names an
+// attribute rather than the element's text, into its parts. attr is empty
+// for a plain element name. An "@" inside a "{uri}" namespace is not a
+// separator.
+func splitTag(tag string) (elem, attr string) {
+	start := 0
+	if strings.HasPrefix(tag, "{") {
+		start = max(strings.Index(tag, "}"), 0)
+	}
+	if i := strings.Index(tag[start:], "@"); i >= 0 {
+		return tag[:start+i], tag[start+i+1:]
+	}
+	return tag, ""
+}
+
+// splitSpace splits an element name of the form "{uri}local", which only
+// matches elements in the namespace uri, into its parts. uri is empty for
+// any other name.
+func splitSpace(elem string) (uri, local string) {
+	if strings.HasPrefix(elem, "{") {
+		if i := strings.Index(elem, "}"); i > 0 {
+			return elem[1:i], elem[i+1:]
+		}
+	}
+	return "", elem
+}
+
+// findTag returns the elements tag refers to; for an attribute tag only
+// elements that carry the attribute are returned. The element name is
+// matched as follows:
+//
+//   - "version" matches the local name, whichever namespace it is in
+//   - "cp:version" matches only elements written with the prefix cp
+//   - "{uri}version" matches only elements in the namespace uri, whatever
+//     prefix (or default namespace) the file binds it to
+func findTag(doc *etree.Document, tag string) []*etree.Element {
+	elem, attr := splitTag(tag)
+	uri, elem := splitSpace(elem)
+	path := "//" + elem
+	if attr != "" {
+		path += "[@" + attr + "]"
+	}
+	els := doc.FindElements(path)
+	if uri == "" {
+		return els
+	}
+	matched := els[:0]
+	for _, el := range els {
+		if el.NamespaceURI() == uri {
+			matched = append(matched, el)
+		}
+	}
+	return matched
+}
+
+// tagValue returns the text or attribute of el that tag refers to.
+func tagValue(el *etree.Element, tag string) string {
+	if _, attr := splitTag(tag); attr != "" {
+		return el.SelectAttrValue(attr, "")
+	}
+	return el.Text()
+}
+
+// setTagValue sets the text or attribute of el that tag refers to. An
+// element's text and its attributes are independent, so "release" and
+// "release@release" may both be rewritten on the same element.
+func setTagValue(el *etree.Element, tag, val string) {
+	if _, attr := splitTag(tag); attr != "" {
+		el.CreateAttr(attr, val)
+		return
+	}
+	el.SetText(val)
+}
+
+// ChangedFiles returns the number of distinct metadata files in changes.
+func ChangedFiles(changes []TagChange) int {
+	seen := make(map[string]bool)
+	for _, c := range changes {
+		seen[c.File] = true
+	}
+	return len(seen)
+}
+
+// releaseUpdates maps every tag in tags to release.
+func releaseUpdates(release string, tags []string) map[string]string {
+	updates := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		updates[tag] = release
+	}
+	return updates
+}
+
+// stampFile sets the archive entry name on each of changes.
+func stampFile(name string, changes []TagChange) []TagChange {
+	for i := range changes {
+		changes[i].File = name
+	}
+	return changes
+}
+
+// Detect reads the VersionTags from the metadata of slx without extracting
+// it to disk. The first value found for each tag is returned. A .mdl
+// model is read with DetectMDL.
+func Detect(slx string) (map[string]string, error) {
+	if IsMDL(slx) {
+		return DetectMDL(slx, VersionTags)
+	}
+	r, closeInput, err := openInput(context.Background(), slx, Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+	return detectReader(r, MetadataFilesFor(filepath.Ext(Unwrapped(slx))), VersionTags)
+}
+
+// detectReader is Detect for an already opened archive, looking for tags
+// in the metadata entries files.
+// A metadata file that fails to parse is passed over as long as another
+// one yields a tag.
+func detectReader(r *zip.Reader, files, tags []string) (map[string]string, error) {
+	found := make(map[string]string)
+	var firstErr error
+	for _, name := range metadataEntries(r, files) {
+		f, err := r.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		doc := etree.NewDocument()
+		_, err = doc.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = MetadataError{File: name, Err: err}
+			}
+			continue
+		}
+		for _, tag := range tags {
+			if _, ok := found[tag]; ok {
+				continue
+			}
+			if els := findTag(doc, tag); len(els) > 0 {
+				found[tag] = tagValue(els[0], tag)
+			}
+		}
+	}
+	if len(found) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return found, nil
+}
+
+// atRelease reports whether every element the conversion rewrites across
+// the metadata entries files of r already reads release, meaning a
+// conversion would change nothing.
+func atRelease(r *zip.Reader, files []string, release string, opts Options) (bool, error) {
+	m, err := findMismatch(r, files, release, opts)
+	return m == nil, err
+}
+
+// findMismatch returns the first element the conversion rewrites (see
+// Options.tagsFor) whose text differs from release, with Old holding its
+// current text, or nil if all match.
+func findMismatch(r *zip.Reader, files []string, release string, opts Options) (*TagChange, error) {
+	for _, name := range metadataEntries(r, files) {
+		f, err := r.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		doc := etree.NewDocument()
+		_, err = doc.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			// unreadable files are never rewritten, so they cannot be
+			// judged against release; conversion reports them instead
+			continue
+		}
+		for _, tag := range append(opts.tagsFor(name), opts.textTagsFor(name)...) {
+			for _, el := range findTag(doc, tag) {
+				v := tagValue(el, tag)
+				if want := opts.newValue(tag, v, release); v != want {
+					return &TagChange{File: name, Tag: tag, Element: el.FullTag(), Old: v, New: want}, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Verify reopens slx and confirms that its metadata carries release in
+// every version tag the conversion is responsible for.
+func Verify(slx, release string, opts Options) error {
+	if IsMDL(slx) {
+		return verifyMDL(slx, release, opts)
+	}
+	r, closeInput, err := openInput(context.Background(), slx, opts)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", slx, err)
+	}
+	defer closeInput()
+
+	files := MetadataFilesFor(filepath.Ext(Unwrapped(slx)))
+	if _, err := checkArchive(r, files, opts.tags()); err != nil {
+		return fmt.Errorf("verify %s: %w", slx, err)
+	}
+	m, err := findMismatch(r, files, release, opts)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", slx, err)
+	}
+	if m != nil {
+		return fmt.Errorf("verify %s: %w: <%s> in %s is %q, want %q", slx, ErrVerifyFailed, m.Tag, m.File, m.Old, m.New)
+	}
+	return nil
+}
+
+// PrimaryRelease picks the release reported by Detect, preferring tags in
+// VersionTags order, then an .mdl model's Version line. It returns "" if
+// none were found.
+func PrimaryRelease(found map[string]string) string {
+	for _, tag := range VersionTags {
+		if val := found[tag]; val != "" {
+			return val
+		}
+	}
+	return found[MDLVersionKey]
+}
+
+// isMetadataFile reports whether the archive entry name is one of files,
+// either at the archive root or below a top-level folder.
+func isMetadataFile(name string, files []string) bool {
+	for _, m := range files {
+		if name == m || strings.HasSuffix(name, "/"+m) {
+			return true
+		}
+	}
+	return false
+}
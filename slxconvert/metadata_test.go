@@ -0,0 +1,200 @@
+package slxconvert
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		tags    []string
+		changes []TagChange
+	}{
+		{
+			name:    "element text",
+			xml:     `<mwcoreProperties><release>R2024b</release><matlabRelease>R2024b</matlabRelease></mwcoreProperties>`,
+			tags:    VersionTags,
+			changes: []TagChange{{Tag: "matlabRelease", Element: "matlabRelease", Old: "R2024b", New: "R2023b"}, {Tag: "release", Element: "release", Old: "R2024b", New: "R2023b"}},
+		},
+		{
+			name:    "namespaced element",
+			xml:     `<cp:coreProperties xmlns:cp="urn:cp"><cp:version>R2024b</cp:version></cp:coreProperties>`,
+			tags:    VersionTags,
+			changes: []TagChange{{Tag: "version", Element: "cp:version", Old: "R2024b", New: "R2023b"}},
+		},
+		{
+			name:    "namespace uri",
+			xml:     `<cp:coreProperties xmlns:cp="urn:cp" xmlns:dc="urn:dc"><cp:version>R2024b</cp:version><dc:version>2.1</dc:version></cp:coreProperties>`,
+			tags:    []string{"{urn:cp}version"},
+			changes: []TagChange{{Tag: "{urn:cp}version", Element: "cp:version", Old: "R2024b", New: "R2023b"}},
+		},
+		{
+			name:    "namespace prefix",
+			xml:     `<cp:coreProperties xmlns:cp="urn:cp" xmlns:dc="urn:dc"><cp:version>R2024b</cp:version><dc:version>2.1</dc:version></cp:coreProperties>`,
+			tags:    []string{"dc:version"},
+			changes: []TagChange{{Tag: "dc:version", Element: "dc:version", Old: "2.1", New: "R2023b"}},
+		},
+		{
+			name:    "default namespace",
+			xml:     `<coreProperties xmlns="urn:cp"><version>R2024b</version><meta xmlns="urn:other"><version>3</version></meta></coreProperties>`,
+			tags:    []string{"{urn:cp}version"},
+			changes: []TagChange{{Tag: "{urn:cp}version", Element: "version", Old: "R2024b", New: "R2023b"}},
+		},
+		{
+			name:    "attribute of namespaced element",
+			xml:     `<x:p xmlns:x="urn:x@1"><x:q release="R2024a"/><q release="R2024a"/></x:p>`,
+			tags:    []string{"{urn:x@1}q@release"},
+			changes: []TagChange{{Tag: "{urn:x@1}q@release", Element: "x:q", Old: "R2024a", New: "R2023b"}},
+		},
+		{
+			name: "already at release",
+			xml:  `<mwcoreProperties><release>R2023b</release></mwcoreProperties>`,
+			tags: VersionTags,
+		},
+		{
+			name: "no matching tags",
+			xml:  `<mwcoreProperties><other>R2024b</other></mwcoreProperties>`,
+			tags: VersionTags,
+		},
+		{
+			name:    "attribute and text on one element",
+			xml:     `<p><release release="R2024a">R2024a</release></p>`,
+			tags:    []string{"release", "release@release"},
+			changes: []TagChange{{Tag: "release", Element: "release", Old: "R2024a", New: "R2023b"}, {Tag: "release@release", Element: "release", Old: "R2024a", New: "R2023b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "props.xml")
+			if err := os.WriteFile(path, []byte(tt.xml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.ReadFile(path)
+
+			changes, err := UpdateVersions(path, releaseUpdates("R2023b", tt.tags), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("changes = %+v, want %+v", changes, tt.changes)
+			}
+
+			after, _ := os.ReadFile(path)
+			if len(tt.changes) == 0 && string(after) != string(before) {
+				t.Error("file rewritten although nothing changed")
+			}
+			if len(tt.changes) > 0 {
+				checkGolden(t, "UpdateVersions/"+filepath.Base(t.Name())+".xml", string(after))
+			}
+		})
+	}
+}
+
+func TestUpdateVersionsDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "props.xml")
+	const xml = `<mwcoreProperties><release>R2024b</release></mwcoreProperties>`
+	if err := os.WriteFile(path, []byte(xml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := UpdateVersions(path, releaseUpdates("R2023b", VersionTags), Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes, want 1", len(changes))
+	}
+	if data, _ := os.ReadFile(path); string(data) != xml {
+		t.Error("dry run modified the file")
+	}
+}
+
+func TestUpdateVersionsMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "props.xml")
+	if err := os.WriteFile(path, []byte("<release>R2024b</release"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateVersions(path, releaseUpdates("R2023b", VersionTags), Options{}); err == nil {
+		t.Fatal("expected an error for malformed XML")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.slx")
+	writeFixture(t, path, modelFixture)
+
+	found, err := Detect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"version": "R2024b", "release": "R2024b", "matlabRelease": "R2024b"}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("Detect = %v, want %v", found, want)
+	}
+	if got := PrimaryRelease(found); got != "R2024b" {
+		t.Errorf("PrimaryRelease = %q, want R2024b", got)
+	}
+}
+
+func TestMetadataPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"metadata/coreProperties.xml", "metadata/custom.xml"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte("<cp/>"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	MetadataFilesByType[".mldatx"] = []string{"metadata/custom.xml"}
+	defer delete(MetadataFilesByType, ".mldatx")
+
+	tests := []struct {
+		ext  string
+		want []string
+	}{
+		{".slx", []string{"metadata/coreProperties.xml"}},
+		{".MLDATX", []string{"metadata/custom.xml"}},
+	}
+	for _, tt := range tests {
+		got, err := MetadataPaths(dir, tt.ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MetadataPaths(%s) = %v, want %v", tt.ext, got, tt.want)
+		}
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	changes := []TagChange{
+		{File: "metadata/coreProperties.xml", Tag: "version"},
+		{File: "metadata/mwcoreProperties.xml", Tag: "release"},
+		{File: "metadata/mwcoreProperties.xml", Tag: "matlabRelease"},
+	}
+	if got := ChangedFiles(changes); got != 2 {
+		t.Errorf("ChangedFiles = %d, want 2", got)
+	}
+	if got := ChangedFiles(nil); got != 0 {
+		t.Errorf("ChangedFiles(nil) = %d, want 0", got)
+	}
+}
+
+func TestTagChangeQualified(t *testing.T) {
+	for _, tt := range []struct {
+		c    TagChange
+		want string
+	}{
+		{TagChange{Tag: "version", Element: "cp:version"}, "cp:version"},
+		{TagChange{Tag: "{urn:x@1}q@release", Element: "x:q"}, "x:q@release"},
+		{TagChange{Tag: MDLVersionKey}, MDLVersionKey},
+	} {
+		if got := tt.c.Qualified(); got != tt.want {
+			t.Errorf("%+v.Qualified() = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
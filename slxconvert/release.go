@@ -0,0 +1,98 @@
+package slxconvert
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SupportedReleases lists the release strings known to be accepted by MATLAB.
+var SupportedReleases = []string{
+	"R2022a", "R2022b",
+	"R2023a", "R2023b",
+	"R2024a", "R2024b",
+	"R2025a", "R2025b",
+}
+
+// ReleasePattern matches the general form of a MATLAB release string.
+var ReleasePattern = regexp.MustCompile(`^R20\d\d[ab]$`)
+
+// IsSupportedRelease reports whether rel is one of the SupportedReleases.
+func IsSupportedRelease(rel string) bool {
+	for _, r := range SupportedReleases {
+		if r == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// looseRelease matches the ways people write a release by hand, e.g.
+// r2023b, R2023B or 2023b.
+var looseRelease = regexp.MustCompile(`^[Rr]?(20\d\d)([abAB])$`)
+
+// NormalizeRelease returns s in the canonical R20YY[a|b] form, accepting
+// any capitalization and a missing leading R, so that "r2023B" and
+// "2023b" both give "R2023b". It does not check that the release is one
+// of the SupportedReleases.
+func NormalizeRelease(s string) (string, error) {
+	m := looseRelease.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return "", fmt.Errorf("%w %q (expected R20YY[a|b], e.g. R2023b)", ErrUnsupportedRelease, s)
+	}
+	return "R" + m[1] + strings.ToLower(m[2]), nil
+}
+
+// Release is a parsed MATLAB release such as R2023b.
+type Release struct {
+	Year int
+	Half rune // 'a' or 'b'
+}
+
+// ParseRelease parses a release string of the form R20YY[a|b].
+func ParseRelease(s string) (Release, error) {
+	if !ReleasePattern.MatchString(s) {
+		return Release{}, fmt.Errorf("%w %q (expected R20YY[a|b])", ErrUnsupportedRelease, s)
+	}
+	year, err := strconv.Atoi(s[1:5])
+	if err != nil {
+		return Release{}, fmt.Errorf("%w %q: %w", ErrUnsupportedRelease, s, err)
+	}
+	return Release{Year: year, Half: rune(s[5])}, nil
+}
+
+// String returns the release in its canonical R20YY[a|b] form.
+func (r Release) String() string {
+	return fmt.Sprintf("R%04d%c", r.Year, r.Half)
+}
+
+// Compare returns -1, 0 or +1 depending on whether r is older than, the
+// same as, or newer than other.
+func (r Release) Compare(other Release) int {
+	switch {
+	case r.Year != other.Year:
+		if r.Year < other.Year {
+			return -1
+		}
+		return 1
+	case r.Half < other.Half:
+		return -1
+	case r.Half > other.Half:
+		return 1
+	}
+	return 0
+}
+
+// CompareReleases parses a and b and compares them as Release.Compare does.
+func CompareReleases(a, b string) (int, error) {
+	ra, err := ParseRelease(a)
+	if err != nil {
+		return 0, err
+	}
+	rb, err := ParseRelease(b)
+	if err != nil {
+		return 0, err
+	}
+	return ra.Compare(rb), nil
+}
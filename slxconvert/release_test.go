@@ -0,0 +1,104 @@
+package slxconvert
+
+import "testing"
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Release
+		wantErr bool
+	}{
+		{"R2023b", Release{2023, 'b'}, false},
+		{"R2025a", Release{2025, 'a'}, false},
+		{"r2023b", Release{}, true},
+		{"R2023c", Release{}, true},
+		{"R23b", Release{}, true},
+		{"", Release{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRelease(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRelease(%q) = %v, %v; want %v, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil && got.String() != tt.in {
+			t.Errorf("ParseRelease(%q).String() = %q", tt.in, got.String())
+		}
+	}
+}
+
+func TestNormalizeRelease(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"R2023b", "R2023b", false},
+		{"r2023b", "R2023b", false},
+		{"R2023B", "R2023b", false},
+		{"2023b", "R2023b", false},
+		{" r2025A ", "R2025a", false},
+		{"R2023c", "", true},
+		{"R23b", "", true},
+		{"RR2023b", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeRelease(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeRelease(%q) = %q, %v; want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCompareReleases(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"R2024a", "R2024b", -1},
+		{"R2024b", "R2025a", -1},
+		{"R2023b", "R2023b", 0},
+		{"R2025a", "R2022b", 1},
+	}
+	for _, tt := range tests {
+		got, err := CompareReleases(tt.a, tt.b)
+		if err != nil || got != tt.want {
+			t.Errorf("CompareReleases(%q, %q) = %d, %v; want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+	if _, err := CompareReleases("R2024a", "latest"); err == nil {
+		t.Error("CompareReleases accepted a malformed release")
+	}
+}
+
+func TestDowngradeRisks(t *testing.T) {
+	features := []Feature{
+		{Name: "A", Introduced: "R2023a"},
+		{Name: "B", Introduced: "R2024b"},
+		{Name: "C", Introduced: "R2025a"},
+	}
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{"R2024b", "R2022b", []string{"A", "B"}},
+		{"R2024b", "R2023a", []string{"B"}},
+		{"R2023a", "R2024b", nil},
+		{"", "R2022a", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, f := range DowngradeRisks(tt.from, tt.to, features) {
+			got = append(got, f.Name)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("DowngradeRisks(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("DowngradeRisks(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+				break
+			}
+		}
+	}
+}
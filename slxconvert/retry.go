@@ -0,0 +1,55 @@
+package slxconvert
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+)
+
+// retryBackoff is the delay before the first retry; it doubles each time.
+var retryBackoff = 200 * time.Millisecond
+
+// transientErrnos are the system errors worth retrying, typically seen on
+// network file systems (NFS, SMB) under load.
+var transientErrnos = []syscall.Errno{
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.EIO,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+}
+
+// isTransient reports whether err looks like a temporary file system
+// failure rather than one that will recur, such as permission denied or a
+// missing file.
+func isTransient(err error) bool {
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// withRetry calls fn, retrying up to opts.Retries times with exponential
+// backoff while it fails with a transient error. fn must be safe to run
+// again after a failure. The last error is returned unchanged.
+func withRetry(ctx context.Context, opts Options, what string, fn func() error) error {
+	delay := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= opts.Retries || !isTransient(err) {
+			return err
+		}
+		opts.logf("%s: %v; retrying in %s (%d/%d)", what, err, delay, attempt+1, opts.Retries)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
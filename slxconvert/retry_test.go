@@ -0,0 +1,55 @@
+package slxconvert
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWithRetry(t *testing.T) {
+	defer func(d time.Duration) { retryBackoff = d }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	transient := &fs.PathError{Op: "write", Path: "x", Err: syscall.EIO}
+	permanent := &fs.PathError{Op: "open", Path: "x", Err: os.ErrPermission}
+
+	tests := []struct {
+		name      string
+		retries   int
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"succeeds after transient errors", 3, 2, transient, 3, false},
+		{"gives up at the limit", 2, 5, transient, 3, true},
+		{"no retries configured", 0, 1, transient, 1, true},
+		{"permanent error is not retried", 3, 1, permanent, 1, true},
+		{"wrapped transient error", 1, 1, fmt.Errorf("copy: %w", transient), 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := withRetry(context.Background(), Options{Retries: tt.retries}, "op", func() error {
+				calls++
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && err != tt.err {
+				t.Errorf("err = %v, want the original %v", err, tt.err)
+			}
+		})
+	}
+}
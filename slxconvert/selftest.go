@@ -0,0 +1,156 @@
+package slxconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selfTestEntry is one file of the archive SelfTest round-trips.
+type selfTestEntry struct {
+	name   string
+	body   string
+	method uint16
+}
+
+// selfTestEntries cover what MATLAB is particular about: a stored entry
+// that must stay stored and a Windows-style name that must come out with
+// forward slashes.
+var selfTestEntries = []selfTestEntry{
+	{"metadata/coreProperties.xml", `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties"><cp:version>R2024b</cp:version></cp:coreProperties>`, zip.Deflate},
+	{"metadata/mwcoreProperties.xml", `<mwcoreProperties><release>R2024b</release></mwcoreProperties>`, zip.Deflate},
+	{"simulink/blockdiagram.xml", `<ModelInformation><Model Name="selftest"/></ModelInformation>`, zip.Deflate},
+	{"simulink/graphicalInterface.xml", `<GraphicalInterface/>`, zip.Store},
+	{`simulink\windows.xml`, `<Windows/>`, zip.Deflate},
+}
+
+// SelfTest builds a known archive, runs it through Unzip and ZipDir, and
+// through the entry-by-entry rewrite that converts archives by default,
+// with opts, and checks that both results keep the invariants MATLAB
+// relies on: the UTF-8 flag is cleared, names use forward slashes, stored
+// entries stay stored while everything else is deflated, and no content
+// is lost. It returns every violation found.
+func SelfTest(ctx context.Context, opts Options) error {
+	dir, err := os.MkdirTemp(opts.WorkDir, "slxconvert_selftest")
+	if err != nil {
+		return err
+	}
+	trackWorkDir(dir)
+	defer removeWorkDir(dir)
+
+	src := filepath.Join(dir, "selftest.slx")
+	if err := writeSelfTestArchive(src); err != nil {
+		return err
+	}
+	work := filepath.Join(dir, "work")
+	manifest, err := Unzip(ctx, src, work, opts)
+	if err != nil {
+		return fmt.Errorf("unzip: %w", err)
+	}
+	zipped := filepath.Join(dir, "zipped.slx")
+	if err := ZipDir(ctx, work, zipped, manifest, opts); err != nil {
+		return fmt.Errorf("zip: %w", err)
+	}
+
+	// the metadata is already at R2024b, so the rewrite changes no content
+	rewritten := filepath.Join(dir, "rewritten.slx")
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	err = WriteAtomic(rewritten, func(w io.Writer) error {
+		_, _, err := rewriteArchive(ctx, &r.Reader, w, MetadataFilesFor(".slx"), "R2024b", opts)
+		return err
+	})
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("rewrite: %w", err)
+	}
+
+	var errs []error
+	for _, pass := range []struct{ name, path string }{{"zip", zipped}, {"rewrite", rewritten}} {
+		for _, err := range checkSelfTestOutput(pass.path, opts) {
+			errs = append(errs, fmt.Errorf("%s: %w", pass.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkSelfTestOutput returns every way in which the archive at path, made
+// from selfTestEntries with opts, breaks the invariants SelfTest checks.
+func checkSelfTestOutput(path string, opts Options) []error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return []error{fmt.Errorf("reopen output: %w", err)}
+	}
+	defer r.Close()
+
+	var errs []error
+	if len(r.File) != len(selfTestEntries) {
+		errs = append(errs, fmt.Errorf("output has %d entries, want %d", len(r.File), len(selfTestEntries)))
+	}
+	for i, f := range r.File {
+		if i >= len(selfTestEntries) {
+			break
+		}
+		want := selfTestEntries[i]
+		name := strings.ReplaceAll(want.name, `\`, "/")
+		if f.Name != name {
+			errs = append(errs, fmt.Errorf("entry %d is %q, want %q", i, f.Name, name))
+		}
+		if f.Flags&utf8Flag != 0 {
+			errs = append(errs, fmt.Errorf("%s: UTF-8 flag is set", f.Name))
+		}
+		if strings.Contains(f.Name, `\`) {
+			errs = append(errs, fmt.Errorf("%s: name contains a backslash", f.Name))
+		}
+		if method := opts.entryMethod(want.method); f.Method != method {
+			errs = append(errs, fmt.Errorf("%s: method %d, want %d", f.Name, f.Method, method))
+		}
+		rc, err := f.Open()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Name, err))
+			continue
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Name, err))
+		} else if string(body) != want.body {
+			errs = append(errs, fmt.Errorf("%s: content changed", f.Name))
+		}
+	}
+	return errs
+}
+
+// writeSelfTestArchive writes selfTestEntries to path the way a generic
+// zip tool would, with the UTF-8 flag set on every entry.
+func writeSelfTestArchive(path string) error {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range selfTestEntries {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     e.name,
+			Method:   e.method,
+			Modified: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+			Flags:    utf8Flag,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, e.body); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
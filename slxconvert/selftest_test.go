@@ -0,0 +1,14 @@
+package slxconvert
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	for _, opts := range []Options{{}, {Store: true}, {CompressionLevel: 9}} {
+		if err := SelfTest(context.Background(), opts); err != nil {
+			t.Errorf("SelfTest(%+v): %v", opts, err)
+		}
+	}
+}